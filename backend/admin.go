@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+// RequireAdmin authenticates the request and rejects users without the admin flag.
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return Authenticate(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := getUserIDFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var user models.User
+		if err := db.Select("id", "is_admin").First(&user, userID).Error; err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if !user.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// parseAdminEmails splits the comma-separated ADMIN_EMAILS setting.
+func parseAdminEmails(v string) []string {
+	var emails []string
+	for _, email := range strings.Split(v, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// grantAdmins sets the admin flag of the existing users with the given
+// emails. It only runs at startup: registration does not verify addresses,
+// so granting it on sign-up would make whoever registers a listed address
+// first an admin. Removing an address from the list does not revoke it.
+func grantAdmins(emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	result := db.Model(&models.User{}).Where("email IN ? AND NOT is_admin", emails).Update("is_admin", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Granted admin to %d users from ADMIN_EMAILS", result.RowsAffected)
+	}
+
+	var found []string
+	if err := db.Model(&models.User{}).Where("email IN ?", emails).Pluck("email", &found).Error; err != nil {
+		return err
+	}
+	for _, email := range emails {
+		if !slices.Contains(found, email) {
+			log.Printf("ADMIN_EMAILS lists %s, which has no account yet", email)
+		}
+	}
+	return nil
+}
+
+// adminRefreshHandler runs a fetch-and-notify cycle immediately and returns
+// the lifecycle events it produced once affected sensors are processed. While
+// another refresh is in progress it answers 409 rather than waiting for it,
+// which can take minutes when SMHI is retried.
+func adminRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	summary, ok, err := tryRefreshWarnings()
+	if !ok {
+		http.Error(w, "A refresh is already in progress", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error refreshing weather warnings: %v", err)
+		http.Error(w, "Error fetching weather warnings", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+func TestParseAdminEmails(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"ops@example.com", []string{"ops@example.com"}},
+		{" ops@example.com , jane@example.com,,", []string{"ops@example.com", "jane@example.com"}},
+	}
+	for _, tt := range tests {
+		if got := parseAdminEmails(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAdminEmails(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAdminRefreshHandlerDoesNotWait(t *testing.T) {
+	refreshMutex.Lock()
+	defer refreshMutex.Unlock()
+
+	w := httptest.NewRecorder()
+	adminRefreshHandler(w, httptest.NewRequest(http.MethodPost, "/api/admin/weather-warnings/refresh", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("status %d while a refresh runs, want 409", w.Code)
+	}
+}
+
+// TestGrantAdmins needs TEST_DATABASE_URL.
+func TestGrantAdmins(t *testing.T) {
+	openTestDB(t)
+	company := models.Company{Name: "Admins"}
+	if err := db.Create(&company).Error; err != nil {
+		t.Fatal(err)
+	}
+	users := []models.User{
+		{Email: "ops@example.com", PasswordHash: "x", CompanyID: company.ID},
+		{Email: "jane@example.com", PasswordHash: "x", CompanyID: company.ID, IsAdmin: true},
+		{Email: "sensor-team@example.com", PasswordHash: "x", CompanyID: company.ID},
+	}
+	if err := db.Create(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := grantAdmins([]string{"ops@example.com", "jane@example.com", "new@example.com"}); err != nil {
+		t.Fatalf("grantAdmins: %v", err)
+	}
+	want := map[string]bool{"ops@example.com": true, "jane@example.com": true, "sensor-team@example.com": false}
+	var stored []models.User
+	db.Find(&stored)
+	if len(stored) != len(want) {
+		t.Fatalf("%d users, want %d", len(stored), len(want))
+	}
+	for _, user := range stored {
+		if user.IsAdmin != want[user.Email] {
+			t.Errorf("%s: admin %v, want %v", user.Email, user.IsAdmin, want[user.Email])
+		}
+	}
+}
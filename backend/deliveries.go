@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"gorm.io/gorm"
+)
+
+// findUserWebhook loads the webhook named by the {id} path value if it
+// belongs to the user, writing the error response otherwise.
+func findUserWebhook(w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	var webhook models.Webhook
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return webhook, false
+	}
+	if err := db.Where("id = ? AND user_id = ?", r.PathValue("id"), userID).First(&webhook).Error; err != nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return webhook, false
+	}
+	return webhook, true
+}
+
+// WebhookDeliveriesHandler serves GET /api/webhooks/{id}/deliveries, newest
+// first with every attempt made. Results can be filtered by state, kind and
+// a from/to creation range, and are paged with page and pageSize.
+func WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	webhook, ok := findUserWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	from, to, err := parseTimeRange(query.Get("from"), query.Get("to"), 30*24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := db.Model(&models.WebhookDelivery{}).
+		Where("webhook_id = ? AND created_at >= ? AND created_at <= ?", webhook.ID, from, to)
+	if state := query.Get("state"); state != "" {
+		switch state {
+		case deliveryPending, deliveryDelivered, deliveryDead:
+		default:
+			http.Error(w, "State must be pending, delivered or dead", http.StatusBadRequest)
+			return
+		}
+		q = q.Where("state = ?", state)
+	}
+	if kind := query.Get("kind"); kind != "" {
+		switch kind {
+		case notificationWarning, notificationOffline, notificationOnline, notificationResolved, notificationThreshold:
+		default:
+			http.Error(w, "Kind must be warning, offline, online, resolved or threshold", http.StatusBadRequest)
+			return
+		}
+		q = q.Where("kind = ?", kind)
+	}
+
+	page, _ := strconv.Atoi(query.Get("page"))
+	pageSize, _ := strconv.Atoi(query.Get("pageSize"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 200 {
+		pageSize = 20
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		log.Printf("Error counting deliveries of webhook %d: %v", webhook.ID, err)
+		http.Error(w, "Error fetching deliveries", http.StatusInternalServerError)
+		return
+	}
+	deliveries := []models.WebhookDelivery{}
+	err = q.Preload("AttemptLog", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Order("created_at DESC, id DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&deliveries).Error
+	if err != nil {
+		log.Printf("Error fetching deliveries of webhook %d: %v", webhook.ID, err)
+		http.Error(w, "Error fetching deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Deliveries []models.WebhookDelivery `json:"deliveries"`
+		Total      int64                    `json:"total"`
+	}{Deliveries: deliveries, Total: total})
+}
+
+// RedeliverWebhookHandler serves
+// POST /api/webhooks/{id}/deliveries/{deliveryID}/redeliver. The payload of
+// a past delivery is queued again as a new delivery with its own ID and
+// attempt budget, linked back through RedeliveryOf.
+func RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	webhook, ok := findUserWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var original models.WebhookDelivery
+	err := db.Where("id = ? AND webhook_id = ?", r.PathValue("deliveryID"), webhook.ID).First(&original).Error
+	if err != nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	delivery := models.WebhookDelivery{
+		WebhookID:     webhook.ID,
+		DeliveryID:    newDeliveryID(),
+		Kind:          original.Kind,
+		Payload:       original.Payload,
+		State:         deliveryPending,
+		NextAttemptAt: time.Now(),
+		RedeliveryOf:  &original.ID,
+	}
+	if err := db.Create(&delivery).Error; err != nil {
+		log.Printf("Error redelivering %s: %v", original.DeliveryID, err)
+		http.Error(w, "Error redelivering", http.StatusInternalServerError)
+		return
+	}
+	wakeWebhookDispatcher()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
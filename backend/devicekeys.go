@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"gorm.io/gorm"
+)
+
+// Device keys look like "wsk_<prefix>.<secret>". The prefix is stored in
+// clear for lookup, the secret only as a hash.
+const (
+	deviceKeyScheme = "wsk_"
+	// defaultRotationGrace is how long a rotated key keeps working.
+	defaultRotationGrace = 24 * time.Hour
+)
+
+const sensorIDKey contextKey = "sensorID"
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashDeviceSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateSensorKey creates a key for a sensor and returns it together with
+// the plaintext, which is never stored.
+func generateSensorKey(tx *gorm.DB, sensorID uint, name string) (models.SensorKey, string, error) {
+	prefixBytes := make([]byte, 6)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return models.SensorKey{}, "", err
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+	secret, err := randomToken(32)
+	if err != nil {
+		return models.SensorKey{}, "", err
+	}
+
+	key := models.SensorKey{SensorID: sensorID, Name: name, Prefix: prefix, Hash: hashDeviceSecret(secret)}
+	if err := tx.Create(&key).Error; err != nil {
+		return models.SensorKey{}, "", err
+	}
+	return key, deviceKeyScheme + prefix + "." + secret, nil
+}
+
+// lookupDeviceKey resolves a plaintext key to its sensor, rejecting revoked
+// and expired keys.
+func lookupDeviceKey(plaintext string, now time.Time) (models.SensorKey, error) {
+	var key models.SensorKey
+	rest, ok := strings.CutPrefix(plaintext, deviceKeyScheme)
+	if !ok {
+		return key, errors.New("malformed key")
+	}
+	prefix, secret, ok := strings.Cut(rest, ".")
+	if !ok || prefix == "" || secret == "" {
+		return key, errors.New("malformed key")
+	}
+
+	if err := db.Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		return key, errors.New("unknown key")
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashDeviceSecret(secret))) != 1 {
+		return key, errors.New("unknown key")
+	}
+	if key.RevokedAt != nil {
+		return key, errors.New("key revoked")
+	}
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return key, errors.New("key expired")
+	}
+	return key, nil
+}
+
+// AuthenticateDevice accepts a sensor key in the X-API-Key header or as a
+// bearer token and attaches the sensor ID it belongs to to the request context.
+func AuthenticateDevice(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plaintext := r.Header.Get("X-API-Key")
+		if plaintext == "" {
+			plaintext = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if plaintext == "" {
+			http.Error(w, "Missing API key", http.StatusUnauthorized)
+			return
+		}
+
+		now := time.Now()
+		key, err := lookupDeviceKey(plaintext, now)
+		if err != nil {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		db.Model(&key).UpdateColumn("last_used_at", now)
+
+		ctx := context.WithValue(r.Context(), sensorIDKey, key.SensorID)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// getDeviceSensor loads the sensor the authenticated key belongs to.
+func getDeviceSensor(ctx context.Context) (models.Sensor, error) {
+	var sensor models.Sensor
+	sensorID, ok := ctx.Value(sensorIDKey).(uint)
+	if !ok {
+		return sensor, errors.New("sensor ID not found in context")
+	}
+	err := db.First(&sensor, sensorID).Error
+	return sensor, err
+}
+
+// DeviceReadingsHandler lets a device push readings for its own sensor.
+func DeviceReadingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sensor, err := getDeviceSensor(r.Context())
+	if err != nil {
+		http.Error(w, "Sensor not found", http.StatusNotFound)
+		return
+	}
+	handleCreateReadings(w, r, sensor)
+}
+
+type SensorKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// sensorKeyResponse includes the plaintext key, returned only on creation.
+type sensorKeyResponse struct {
+	models.SensorKey
+	Key string `json:"key"`
+}
+
+// SensorKeysHandler serves /api/sensors/{id}/keys.
+func SensorKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sensor, status, err := findCompanySensor(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		keys := []models.SensorKey{}
+		if err := db.Where("sensor_id = ?", sensor.ID).Order("id").Find(&keys).Error; err != nil {
+			http.Error(w, "Error fetching keys", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	case http.MethodPost:
+		var req SensorKeyRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
+				return
+			}
+		}
+		key, plaintext, err := generateSensorKey(db, sensor.ID, req.Name)
+		if err != nil {
+			log.Printf("Error creating key for sensor %d: %v", sensor.ID, err)
+			http.Error(w, "Error creating key", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sensorKeyResponse{SensorKey: key, Key: plaintext})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// findSensorKey loads a key by its path ID, scoped to the sensor.
+func findSensorKey(w http.ResponseWriter, r *http.Request) (models.Sensor, models.SensorKey, bool) {
+	var key models.SensorKey
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return models.Sensor{}, key, false
+	}
+	sensor, status, err := findCompanySensor(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return sensor, key, false
+	}
+	keyID, err := strconv.ParseUint(r.PathValue("keyID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid key ID", http.StatusBadRequest)
+		return sensor, key, false
+	}
+	if err := db.Where("id = ? AND sensor_id = ?", keyID, sensor.ID).First(&key).Error; err != nil {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return sensor, key, false
+	}
+	return sensor, key, true
+}
+
+// RevokeSensorKeyHandler serves DELETE /api/sensors/{id}/keys/{keyID}.
+func RevokeSensorKeyHandler(w http.ResponseWriter, r *http.Request) {
+	_, key, ok := findSensorKey(w, r)
+	if !ok {
+		return
+	}
+	if key.RevokedAt == nil {
+		now := time.Now()
+		if err := db.Model(&key).Update("revoked_at", now).Error; err != nil {
+			http.Error(w, "Error revoking key", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateSensorKeyHandler serves POST /api/sensors/{id}/keys/{keyID}/rotate.
+// It issues a replacement key and lets the old one keep working for the
+// grace period given as a Go duration in ?grace= (default 24h, 0 revokes it
+// immediately).
+func RotateSensorKeyHandler(w http.ResponseWriter, r *http.Request) {
+	sensor, key, ok := findSensorKey(w, r)
+	if !ok {
+		return
+	}
+	if key.RevokedAt != nil {
+		http.Error(w, "Key already revoked", http.StatusConflict)
+		return
+	}
+
+	grace := defaultRotationGrace
+	if v := r.URL.Query().Get("grace"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "Invalid grace period", http.StatusBadRequest)
+			return
+		}
+		grace = d
+	}
+
+	var replacement models.SensorKey
+	var plaintext string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		replacement, plaintext, err = generateSensorKey(tx, sensor.ID, key.Name)
+		if err != nil {
+			return err
+		}
+		expiresAt := time.Now().Add(grace)
+		if key.ExpiresAt != nil && key.ExpiresAt.Before(expiresAt) {
+			expiresAt = *key.ExpiresAt
+		}
+		return tx.Model(&key).Update("expires_at", expiresAt).Error
+	})
+	if err != nil {
+		log.Printf("Error rotating key %d: %v", key.ID, err)
+		http.Error(w, "Error rotating key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sensorKeyResponse{SensorKey: replacement, Key: plaintext})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+// Where the cached warnings came from.
+const (
+	sourceNone     = "none"
+	sourceLive     = "live"
+	sourceSnapshot = "snapshot"
+)
+
+// fetchStatus describes the health of the warnings fetcher.
+type fetchStatus struct {
+	Provider            string     `json:"provider"`
+	Source              string     `json:"source"`
+	Degraded            bool       `json:"degraded"`
+	LastAttempt         *time.Time `json:"last_attempt"`
+	LastSuccess         *time.Time `json:"last_success"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	SnapshotFetchedAt   *time.Time `json:"snapshot_fetched_at,omitempty"`
+	WarningCount        int        `json:"warning_count"`
+}
+
+var (
+	fetchState      = fetchStatus{Source: sourceNone, Degraded: true}
+	fetchStateMutex sync.Mutex
+)
+
+// recordFetchResult updates the fetch status after an attempt.
+func recordFetchResult(provider string, count int, err error) {
+	now := time.Now()
+
+	fetchStateMutex.Lock()
+	defer fetchStateMutex.Unlock()
+
+	fetchState.Provider = provider
+	fetchState.LastAttempt = &now
+	if err != nil {
+		fetchState.LastError = err.Error()
+		fetchState.ConsecutiveFailures++
+		fetchState.Degraded = true
+		return
+	}
+	fetchState.LastSuccess = &now
+	fetchState.LastError = ""
+	fetchState.ConsecutiveFailures = 0
+	fetchState.Source = sourceLive
+	fetchState.SnapshotFetchedAt = nil
+	fetchState.Degraded = false
+	fetchState.WarningCount = count
+}
+
+// markSnapshotSource records that the cache was filled from the stored
+// snapshot rather than a live fetch.
+func markSnapshotSource(fetchedAt time.Time, count int) {
+	fetchStateMutex.Lock()
+	defer fetchStateMutex.Unlock()
+
+	fetchState.Source = sourceSnapshot
+	fetchState.SnapshotFetchedAt = &fetchedAt
+	fetchState.WarningCount = count
+	fetchState.Degraded = true
+}
+
+// saveWarningSnapshot stores the latest successful fetch.
+func saveWarningSnapshot(warnings []Warning, fetchedAt time.Time) error {
+	data, err := json.Marshal(warnings)
+	if err != nil {
+		return err
+	}
+	return db.Save(&models.WarningSnapshot{ID: 1, Data: data, FetchedAt: fetchedAt}).Error
+}
+
+// loadWarningSnapshot returns the last stored fetch and when it was taken.
+func loadWarningSnapshot() ([]Warning, time.Time, error) {
+	var snapshot models.WarningSnapshot
+	if err := db.First(&snapshot, 1).Error; err != nil {
+		return nil, time.Time{}, err
+	}
+	var warnings []Warning
+	if err := json.Unmarshal(snapshot.Data, &warnings); err != nil {
+		return nil, time.Time{}, err
+	}
+	return warnings, snapshot.FetchedAt, nil
+}
+
+// weatherWarningStatusHandler reports the fetcher health.
+func weatherWarningStatusHandler(w http.ResponseWriter, r *http.Request) {
+	fetchStateMutex.Lock()
+	status := fetchState
+	fetchStateMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
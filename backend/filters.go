@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+// Event kinds a webhook can subscribe to. Each covers a pair of notification
+// kinds, the notification and its recovery.
+const (
+	eventKindWarning   = "warning"
+	eventKindOffline   = "offline"
+	eventKindThreshold = "threshold"
+)
+
+// eventKindOf maps a notification kind onto the event kind it belongs to.
+func eventKindOf(kind string) string {
+	switch kind {
+	case notificationWarning, notificationResolved:
+		return eventKindWarning
+	case notificationOffline, notificationOnline:
+		return eventKindOffline
+	default:
+		return kind
+	}
+}
+
+// notification is a payload about a sensor together with the facts webhook
+// filters are evaluated against and chat formats are rendered from. Level,
+// EventCode, Match and Changes are only set for warning notifications, and
+// Level and EventCode for resolved ones, where they describe the warning
+// that was lowered.
+type notification struct {
+	Kind      string
+	Sensor    models.Sensor
+	Level     severity
+	EventCode string
+	Match     areaMatch
+	Changes   []lifecycleKind
+	Payload   map[string]interface{}
+}
+
+func newNotification(sensor models.Sensor, payload map[string]interface{}) notification {
+	kind, _ := payload["kind"].(string)
+	return notification{Kind: kind, Sensor: sensor, Payload: payload}
+}
+
+// resolvedNotification reports that a sensor's status was lowered from
+// previous. eventCode is the event of the warning that had raised it, empty
+// when that is no longer known.
+func resolvedNotification(sensor models.Sensor, previous, eventCode string) notification {
+	n := newNotification(sensor, resolvedPayload(sensor, previous))
+	n.Level = parseSeverity(previous)
+	n.EventCode = eventCode
+	return n
+}
+
+// webhookFilter is the decoded form of a webhook's filter columns. Empty
+// fields match everything.
+type webhookFilter struct {
+	MinLevel   severity
+	EventCodes []string
+	SensorIDs  []uint
+	SensorTags []string
+	EventKinds []string
+}
+
+// decodeList reads a JSON array column, treating NULL and malformed values
+// as an empty list.
+func decodeList[T any](data models.JSON) []T {
+	var list []T
+	if len(data) > 0 {
+		json.Unmarshal(data, &list)
+	}
+	return list
+}
+
+func encodeList[T any](list []T) models.JSON {
+	if len(list) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(list)
+	return data
+}
+
+func filterOf(webhook models.Webhook) webhookFilter {
+	return webhookFilter{
+		MinLevel:   parseSeverity(webhook.MinWarningLevel),
+		EventCodes: decodeList[string](webhook.EventCodes),
+		SensorIDs:  decodeList[uint](webhook.SensorIDs),
+		SensorTags: decodeList[string](webhook.SensorTags),
+		EventKinds: decodeList[string](webhook.EventKinds),
+	}
+}
+
+// accepts reports whether a notification passes the filter. Sensor and event
+// kind filters apply to every notification, level and event code filters to
+// warnings and to resolved notifications, which are matched on the warning
+// that was lowered so a webhook only hears the end of warnings it was sent.
+func (f webhookFilter) accepts(n notification) bool {
+	if len(f.EventKinds) > 0 && !containsFold(f.EventKinds, eventKindOf(n.Kind)) {
+		return false
+	}
+	if len(f.SensorIDs) > 0 || len(f.SensorTags) > 0 {
+		matched := false
+		for _, id := range f.SensorIDs {
+			matched = matched || id == n.Sensor.ID
+		}
+		for _, tag := range decodeList[string](n.Sensor.Tags) {
+			matched = matched || containsFold(f.SensorTags, tag)
+		}
+		if !matched {
+			return false
+		}
+	}
+	if n.Kind != notificationWarning && n.Kind != notificationResolved {
+		return true
+	}
+	if f.MinLevel != severityNone && n.Level < f.MinLevel {
+		return false
+	}
+	if len(f.EventCodes) > 0 && !containsFold(f.EventCodes, n.EventCode) {
+		return false
+	}
+	return true
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterWebhooks returns the webhooks whose filters accept the notification.
+func filterWebhooks(webhooks []models.Webhook, n notification) []models.Webhook {
+	var accepted []models.Webhook
+	for _, webhook := range webhooks {
+		if filterOf(webhook).accepts(n) {
+			accepted = append(accepted, webhook)
+		}
+	}
+	return accepted
+}
+
+// WebhookFilterRequest holds the filter fields of a webhook request. A field
+// that is omitted or null leaves the filter as it is, an empty value clears
+// it.
+type WebhookFilterRequest struct {
+	MinWarningLevel *string   `json:"min_warning_level"`
+	EventCodes      *[]string `json:"event_codes"`
+	SensorIDs       *[]uint   `json:"sensor_ids"`
+	SensorTags      *[]string `json:"sensor_tags"`
+	EventKinds      *[]string `json:"event_kinds"`
+}
+
+// errFilterLookup is returned by validate when the sensors could not be
+// checked, as opposed to the request being invalid.
+var errFilterLookup = errors.New("error checking sensor_ids")
+
+func (req WebhookFilterRequest) validate(companyID uint) error {
+	if req.MinWarningLevel != nil && *req.MinWarningLevel != "" && parseSeverity(*req.MinWarningLevel) == severityNone {
+		return errors.New("min_warning_level must be YELLOW, ORANGE or RED")
+	}
+	if req.EventKinds != nil {
+		for _, kind := range *req.EventKinds {
+			switch kind {
+			case eventKindWarning, eventKindOffline, eventKindThreshold:
+			default:
+				return fmt.Errorf("Unknown event kind %q", kind)
+			}
+		}
+	}
+	if req.SensorIDs != nil && len(*req.SensorIDs) > 0 {
+		ids := uniqueIDs(*req.SensorIDs)
+		var count int64
+		err := db.Model(&models.Sensor{}).Where("id IN ? AND company_id = ?", ids, companyID).Count(&count).Error
+		if err != nil {
+			return fmt.Errorf("%w: %v", errFilterLookup, err)
+		}
+		if int(count) != len(ids) {
+			return errors.New("sensor_ids contains unknown sensors")
+		}
+	}
+	return nil
+}
+
+// apply copies the filters that were sent onto the webhook.
+func (req WebhookFilterRequest) apply(webhook *models.Webhook) {
+	if req.MinWarningLevel != nil {
+		webhook.MinWarningLevel = ""
+		if *req.MinWarningLevel != "" {
+			webhook.MinWarningLevel = parseSeverity(*req.MinWarningLevel).String()
+		}
+	}
+	if req.EventCodes != nil {
+		webhook.EventCodes = encodeList(*req.EventCodes)
+	}
+	if req.SensorIDs != nil {
+		webhook.SensorIDs = encodeList(uniqueIDs(*req.SensorIDs))
+	}
+	if req.SensorTags != nil {
+		webhook.SensorTags = encodeList(*req.SensorTags)
+	}
+	if req.EventKinds != nil {
+		webhook.EventKinds = encodeList(*req.EventKinds)
+	}
+}
+
+// uniqueIDs returns ids without repeats, in their original order.
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
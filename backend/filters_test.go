@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+func TestWebhookFilterAccepts(t *testing.T) {
+	sensor := models.Sensor{Status: statusOK}
+	sensor.ID = 7
+	warning := func(level, eventCode string) notification {
+		n := newNotification(sensor, map[string]interface{}{"kind": notificationWarning})
+		n.Level = parseSeverity(level)
+		n.EventCode = eventCode
+		return n
+	}
+	offline := newNotification(sensor, sensorPayload(sensor, notificationOffline))
+
+	tests := []struct {
+		name   string
+		filter webhookFilter
+		n      notification
+		want   bool
+	}{
+		{"no filters", webhookFilter{}, warning("YELLOW", "WIND"), true},
+		{"below the minimum level", webhookFilter{MinLevel: severityRed}, warning("ORANGE", "WIND"), false},
+		{"at the minimum level", webhookFilter{MinLevel: severityRed}, warning("RED", "WIND"), true},
+		{"other event code", webhookFilter{EventCodes: []string{"FIRE"}}, warning("RED", "WIND"), false},
+		{"event codes ignore case", webhookFilter{EventCodes: []string{"fire"}}, warning("RED", "FIRE"), true},
+		{"other sensor", webhookFilter{SensorIDs: []uint{8}}, warning("RED", "WIND"), false},
+		{"other event kind", webhookFilter{EventKinds: []string{eventKindOffline}}, warning("RED", "WIND"), false},
+		{"level filter ignores offline", webhookFilter{MinLevel: severityRed, EventCodes: []string{"FIRE"}}, offline, true},
+
+		{"resolved without filters", webhookFilter{},
+			resolvedNotification(sensor, statusYellow, "WIND"), true},
+		{"resolved below the minimum level", webhookFilter{MinLevel: severityRed},
+			resolvedNotification(sensor, statusYellow, "WIND"), false},
+		{"resolved at the minimum level", webhookFilter{MinLevel: severityOrange},
+			resolvedNotification(sensor, statusRed, "WIND"), true},
+		{"resolved other event code", webhookFilter{EventCodes: []string{"FIRE"}},
+			resolvedNotification(sensor, statusRed, "WIND"), false},
+		{"resolved same event code", webhookFilter{EventCodes: []string{"FIRE"}},
+			resolvedNotification(sensor, statusRed, "FIRE"), true},
+		{"resolved unknown event code", webhookFilter{EventCodes: []string{"FIRE"}},
+			resolvedNotification(sensor, statusRed, ""), false},
+		{"resolved to offline subscribers", webhookFilter{EventKinds: []string{eventKindOffline}},
+			resolvedNotification(sensor, statusRed, "FIRE"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.accepts(tt.n); got != tt.want {
+				t.Errorf("accepts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookFilterRequestApply(t *testing.T) {
+	filtered := func() models.Webhook {
+		return models.Webhook{
+			MinWarningLevel: "ORANGE",
+			EventCodes:      models.JSON(`["FIRE"]`),
+			SensorIDs:       models.JSON(`[1,2]`),
+			SensorTags:      models.JSON(`["roof"]`),
+			EventKinds:      models.JSON(`["warning"]`),
+		}
+	}
+	tests := []struct {
+		name string
+		body string
+		want webhookFilter
+	}{
+		{"nothing sent", `{"format":"slack"}`, webhookFilter{
+			MinLevel: severityOrange, EventCodes: []string{"FIRE"}, SensorIDs: []uint{1, 2},
+			SensorTags: []string{"roof"}, EventKinds: []string{"warning"},
+		}},
+		{"null leaves a filter", `{"event_codes":null,"min_warning_level":null}`, webhookFilter{
+			MinLevel: severityOrange, EventCodes: []string{"FIRE"}, SensorIDs: []uint{1, 2},
+			SensorTags: []string{"roof"}, EventKinds: []string{"warning"},
+		}},
+		{"one filter replaced", `{"event_codes":["WIND","RAIN"]}`, webhookFilter{
+			MinLevel: severityOrange, EventCodes: []string{"WIND", "RAIN"}, SensorIDs: []uint{1, 2},
+			SensorTags: []string{"roof"}, EventKinds: []string{"warning"},
+		}},
+		{"empty values clear", `{"min_warning_level":"","event_codes":[],"sensor_ids":[],"sensor_tags":[],"event_kinds":[]}`,
+			webhookFilter{}},
+		{"level normalised", `{"min_warning_level":"red"}`, webhookFilter{
+			MinLevel: severityRed, EventCodes: []string{"FIRE"}, SensorIDs: []uint{1, 2},
+			SensorTags: []string{"roof"}, EventKinds: []string{"warning"},
+		}},
+		{"duplicate sensors stored once", `{"sensor_ids":[3,1,3,1]}`, webhookFilter{
+			MinLevel: severityOrange, EventCodes: []string{"FIRE"}, SensorIDs: []uint{3, 1},
+			SensorTags: []string{"roof"}, EventKinds: []string{"warning"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req WebhookRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			webhook := filtered()
+			req.apply(&webhook)
+			if got := filterOf(webhook); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filters after apply = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookFilterRequestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{"empty", `{}`, true},
+		{"cleared level", `{"min_warning_level":""}`, true},
+		{"unknown level", `{"min_warning_level":"PURPLE"}`, false},
+		{"known event kinds", `{"event_kinds":["warning","offline","threshold"]}`, true},
+		{"notification kind instead of event kind", `{"event_kinds":["resolved"]}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req WebhookFilterRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if err := req.validate(1); (err == nil) != tt.ok {
+				t.Errorf("validate = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+// TestWebhookFilterRequestValidateSensorIDs needs TEST_DATABASE_URL.
+func TestWebhookFilterRequestValidateSensorIDs(t *testing.T) {
+	openTestDB(t)
+	companies := []models.Company{{Name: "Own"}, {Name: "Other"}}
+	if err := db.Create(&companies).Error; err != nil {
+		t.Fatal(err)
+	}
+	sensors := []models.Sensor{
+		{Name: "Visby", CompanyID: companies[0].ID},
+		{Name: "Slite", CompanyID: companies[0].ID},
+		{Name: "Elsewhere", CompanyID: companies[1].ID},
+	}
+	if err := db.Create(&sensors).Error; err != nil {
+		t.Fatal(err)
+	}
+	own, own2, other := sensors[0].ID, sensors[1].ID, sensors[2].ID
+
+	tests := []struct {
+		name string
+		ids  []uint
+		ok   bool
+	}{
+		{"own sensors", []uint{own, own2}, true},
+		{"duplicates", []uint{own, own2, own, own}, true},
+		{"cleared", []uint{}, true},
+		{"another company's sensor", []uint{own, other}, false},
+		{"only another company's sensor", []uint{other}, false},
+		{"unknown sensor", []uint{own, other + 100}, false},
+		{"duplicated unknown sensor", []uint{other + 100, other + 100}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := WebhookFilterRequest{SensorIDs: &tt.ids}
+			if err := req.validate(companies[0].ID); (err == nil) != tt.ok {
+				t.Errorf("validate = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestUniqueIDs(t *testing.T) {
+	if got, want := uniqueIDs([]uint{4, 2, 4, 4, 9, 2}), []uint{4, 2, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("uniqueIDs = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Payload formats a webhook can choose. Generic is the plain JSON object
+// built by the *Payload functions, the others target chat tools.
+const (
+	formatGeneric = "generic"
+	formatSlack   = "slack"
+	formatTeams   = "teams"
+	formatDiscord = "discord"
+)
+
+// Languages for the text of chat formats.
+const (
+	languageEnglish = "en"
+	languageSwedish = "sv"
+)
+
+// webhookSchemaVersion is sent as "schema_version" in generic payloads. Bump
+// it when a field is renamed or removed, adding fields is compatible.
+const webhookSchemaVersion = 1
+
+// Severity colours used by every chat format.
+const (
+	colorOK      = "#2EB67D"
+	colorYellow  = "#F2C744"
+	colorOrange  = "#F28C28"
+	colorRed     = "#D7263D"
+	colorOffline = "#8A8A8A"
+)
+
+// validateFormat checks a webhook's format and language. Empty values select
+// the defaults.
+func validateFormat(format, language string) error {
+	switch format {
+	case "", formatGeneric, formatSlack, formatTeams, formatDiscord:
+	default:
+		return errors.New("Format must be generic, slack, teams or discord")
+	}
+	switch language {
+	case "", languageEnglish, languageSwedish:
+	default:
+		return errors.New("Language must be en or sv")
+	}
+	return nil
+}
+
+// warningTimeZone is the zone time windows are shown in, SMHI warnings are
+// always about Sweden.
+var warningTimeZone = func() *time.Location {
+	loc, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}()
+
+// message is the format-neutral content of a chat notification.
+type message struct {
+	Title  string
+	Text   string
+	Color  string
+	Fields []messageField
+	Window string
+}
+
+type messageField struct {
+	Name  string
+	Value string
+}
+
+// translate picks the English or Swedish variant of a text.
+func translate(language, en, sv string) string {
+	if language == languageSwedish && sv != "" {
+		return sv
+	}
+	return en
+}
+
+func severityColor(level severity) string {
+	switch level {
+	case severityYellow:
+		return colorYellow
+	case severityOrange:
+		return colorOrange
+	case severityRed:
+		return colorRed
+	default:
+		return colorOK
+	}
+}
+
+func statusColor(status string) string {
+	if status == statusOffline {
+		return colorOffline
+	}
+	return severityColor(parseSeverity(status))
+}
+
+func levelName(level severity, language string) string {
+	switch level {
+	case severityYellow:
+		return translate(language, "Yellow", "Gul")
+	case severityOrange:
+		return translate(language, "Orange", "Orange")
+	case severityRed:
+		return translate(language, "Red", "Röd")
+	default:
+		return "OK"
+	}
+}
+
+// statusName is the display name of a sensor status.
+func statusName(status, language string) string {
+	switch status {
+	case statusOffline:
+		return "Offline"
+	case statusMaintenance:
+		return translate(language, "Maintenance", "Underhåll")
+	default:
+		return levelName(parseSeverity(status), language)
+	}
+}
+
+var lifecycleNames = map[lifecycleKind][2]string{
+	lifecycleNew:             {"new", "ny"},
+	lifecycleGeometryChanged: {"area changed", "området ändrat"},
+	lifecycleEscalated:       {"escalated", "uppgraderad"},
+	lifecycleDowngraded:      {"downgraded", "nedgraderad"},
+	lifecycleTimeChanged:     {"time changed", "tid ändrad"},
+	lifecycleCancelled:       {"cancelled", "avblåst"},
+	lifecycleExpired:         {"expired", "upphört"},
+}
+
+func formatWindowTime(t time.Time) string {
+	return t.In(warningTimeZone).Format("2006-01-02 15:04")
+}
+
+// timeWindow describes when a warning area applies.
+func timeWindow(area *WarningArea, language string) string {
+	start, end := parseSMHITime(area.ApproximateStart), parseSMHITime(area.ApproximateEnd)
+	switch {
+	case start != nil && end != nil:
+		return formatWindowTime(*start) + " – " + formatWindowTime(*end)
+	case start != nil:
+		return translate(language, "From ", "Från ") + formatWindowTime(*start) +
+			translate(language, " until further notice", " tills vidare")
+	default:
+		return ""
+	}
+}
+
+func formatNumber(value float64, language string) string {
+	s := strconv.FormatFloat(value, 'f', -1, 64)
+	if language == languageSwedish {
+		s = strings.Replace(s, ".", ",", 1)
+	}
+	return s
+}
+
+// describeNotification renders the text of a notification in a language.
+func describeNotification(n notification, language string) message {
+	sensorField := messageField{Name: translate(language, "Sensor", "Sensor"), Value: n.Sensor.Name}
+	statusField := messageField{Name: translate(language, "Status", "Status"), Value: statusName(n.Sensor.Status, language)}
+
+	switch n.Kind {
+	case notificationWarning:
+		match := n.Match
+		msg := message{Color: severityColor(n.Level), Fields: []messageField{sensorField, statusField}}
+		event := translate(language, match.Warning.Event.En, match.Warning.Event.Sv)
+		msg.Title = fmt.Sprintf("%s %s: %s", levelName(n.Level, language), translate(language, "warning", "varning"), event)
+
+		areaName := translate(language, match.Area.AreaName.En, match.Area.AreaName.Sv)
+		if areaName == "" {
+			areaName = translate(language, match.Warning.AreaName.En, match.Warning.AreaName.Sv)
+		}
+		if match.Proximity == proximityInside {
+			msg.Text = fmt.Sprintf(translate(language, "%s is inside the warning area", "%s ligger inom varningsområdet"), n.Sensor.Name)
+		} else {
+			msg.Text = fmt.Sprintf(translate(language, "%s is %s km from the warning area", "%s ligger %s km från varningsområdet"),
+				n.Sensor.Name, formatNumber(math.Round(match.DistanceKm*10)/10, language))
+		}
+		if areaName != "" {
+			msg.Text += " (" + areaName + ")"
+		}
+		for _, description := range match.Area.Descriptions {
+			if text := translate(language, description.Text.En, description.Text.Sv); text != "" {
+				msg.Text += "\n" + text
+				break
+			}
+		}
+
+		var changes []string
+		for _, kind := range n.Changes {
+			if names, ok := lifecycleNames[kind]; ok {
+				changes = append(changes, translate(language, names[0], names[1]))
+			}
+		}
+		if len(changes) > 0 {
+			msg.Fields = append(msg.Fields, messageField{Name: translate(language, "Change", "Ändring"), Value: strings.Join(changes, ", ")})
+		}
+		msg.Window = timeWindow(match.Area, language)
+		return msg
+
+	case notificationResolved:
+		previous, _ := n.Payload["previous_status"].(string)
+		return message{
+			Title: fmt.Sprintf(translate(language, "Warning lowered for %s", "Varning sänkt för %s"), n.Sensor.Name),
+			Text: fmt.Sprintf(translate(language, "Status changed from %s to %s", "Status ändrad från %s till %s"),
+				statusName(previous, language), statusName(n.Sensor.Status, language)),
+			Color:  statusColor(n.Sensor.Status),
+			Fields: []messageField{sensorField, statusField},
+		}
+
+	case notificationOffline:
+		msg := message{
+			Title:  fmt.Sprintf(translate(language, "%s is offline", "%s är offline"), n.Sensor.Name),
+			Color:  colorOffline,
+			Fields: []messageField{sensorField, statusField},
+		}
+		if n.Sensor.LastSeenAt != nil {
+			msg.Text = translate(language, "Last seen ", "Senast sedd ") + formatWindowTime(*n.Sensor.LastSeenAt)
+		}
+		return msg
+
+	case notificationOnline:
+		return message{
+			Title:  fmt.Sprintf(translate(language, "%s is back online", "%s är online igen"), n.Sensor.Name),
+			Color:  colorOK,
+			Fields: []messageField{sensorField, statusField},
+		}
+
+	case notificationThreshold:
+		state, _ := n.Payload["state"].(string)
+		ruleName, _ := n.Payload["rule_name"].(string)
+		metric, _ := n.Payload["metric"].(string)
+		value, _ := n.Payload["value"].(float64)
+		threshold, _ := n.Payload["threshold"].(float64)
+		msg := message{
+			Title: fmt.Sprintf(translate(language, "Alert rule %s triggered", "Larmregel %s utlöst"), ruleName),
+			Color: colorOrange,
+		}
+		if state == ruleResolved {
+			msg.Title = fmt.Sprintf(translate(language, "Alert rule %s resolved", "Larmregel %s återställd"), ruleName)
+			msg.Color = colorOK
+		}
+		msg.Text = fmt.Sprintf(translate(language, "%s on %s is %s (threshold %s)", "%s på %s är %s (gräns %s)"),
+			metric, n.Sensor.Name, formatNumber(value, language), formatNumber(threshold, language))
+		msg.Fields = []messageField{sensorField, {Name: translate(language, "Metric", "Mätvärde"), Value: metric}}
+		return msg
+
+	default:
+		return message{Title: n.Kind, Color: statusColor(n.Sensor.Status), Fields: []messageField{sensorField, statusField}}
+	}
+}
+
+// renderPayload builds the body sent to a webhook in its format.
+func renderPayload(n notification, format, language string) map[string]interface{} {
+	if format == "" || format == formatGeneric {
+		payload := make(map[string]interface{}, len(n.Payload)+1)
+		for k, v := range n.Payload {
+			payload[k] = v
+		}
+		payload["schema_version"] = webhookSchemaVersion
+		return payload
+	}
+
+	msg := describeNotification(n, language)
+	switch format {
+	case formatSlack:
+		return slackPayload(msg)
+	case formatTeams:
+		return teamsPayload(msg)
+	default:
+		return discordPayload(msg)
+	}
+}
+
+// slackEscaper escapes the characters Slack treats as control sequences in
+// mrkdwn, so names and texts cannot turn into links or mentions.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackPayload renders Block Kit blocks inside an attachment, the only place
+// Slack shows a colour bar. Every mrkdwn text is escaped, the header is
+// plain_text and shown as is.
+func slackPayload(msg message) map[string]interface{} {
+	escape := slackEscaper.Replace
+	blocks := []interface{}{
+		map[string]interface{}{"type": "header", "text": map[string]interface{}{"type": "plain_text", "text": msg.Title}},
+	}
+	if msg.Text != "" {
+		blocks = append(blocks, map[string]interface{}{"type": "section", "text": map[string]interface{}{"type": "mrkdwn", "text": escape(msg.Text)}})
+	}
+	if len(msg.Fields) > 0 {
+		fields := make([]interface{}, 0, len(msg.Fields))
+		for _, field := range msg.Fields {
+			fields = append(fields, map[string]interface{}{"type": "mrkdwn", "text": "*" + escape(field.Name) + "*\n" + escape(field.Value)})
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+	if msg.Window != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type":     "context",
+			"elements": []interface{}{map[string]interface{}{"type": "mrkdwn", "text": ":clock3: " + escape(msg.Window)}},
+		})
+	}
+	return map[string]interface{}{
+		"text":        escape(msg.Title),
+		"attachments": []interface{}{map[string]interface{}{"color": msg.Color, "blocks": blocks}},
+	}
+}
+
+// teamsColor maps a colour onto the named colours Adaptive Cards support.
+func teamsColor(color string) string {
+	switch color {
+	case colorRed:
+		return "Attention"
+	case colorOrange, colorYellow:
+		return "Warning"
+	case colorOK:
+		return "Good"
+	default:
+		return "Default"
+	}
+}
+
+// teamsPayload renders an Adaptive Card message for Teams incoming webhooks
+// and workflows.
+func teamsPayload(msg message) map[string]interface{} {
+	body := []interface{}{
+		map[string]interface{}{"type": "TextBlock", "text": msg.Title, "weight": "Bolder", "size": "Medium", "wrap": true, "color": teamsColor(msg.Color)},
+	}
+	if msg.Text != "" {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": msg.Text, "wrap": true})
+	}
+	facts := make([]interface{}, 0, len(msg.Fields)+1)
+	for _, field := range msg.Fields {
+		facts = append(facts, map[string]interface{}{"title": field.Name, "value": field.Value})
+	}
+	if len(facts) > 0 {
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+	}
+	if msg.Window != "" {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": msg.Window, "isSubtle": true, "wrap": true})
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{map[string]interface{}{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+			},
+		}},
+	}
+}
+
+// discordPayload renders a single embed. Discord colours are integers.
+func discordPayload(msg message) map[string]interface{} {
+	color, _ := strconv.ParseInt(strings.TrimPrefix(msg.Color, "#"), 16, 32)
+	fields := make([]interface{}, 0, len(msg.Fields))
+	for _, field := range msg.Fields {
+		fields = append(fields, map[string]interface{}{"name": field.Name, "value": field.Value, "inline": true})
+	}
+	embed := map[string]interface{}{
+		"title":     msg.Title,
+		"color":     color,
+		"fields":    fields,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+	if msg.Text != "" {
+		embed["description"] = msg.Text
+	}
+	if msg.Window != "" {
+		embed["footer"] = map[string]interface{}{"text": msg.Window}
+	}
+	return map[string]interface{}{"embeds": []interface{}{embed}}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata" // Times are shown in Europe/Stockholm whatever the host has installed
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenTime replaces every clock reading in rendered payloads.
+var goldenTime = time.Date(2025, 1, 14, 12, 0, 0, 0, time.UTC)
+
+// goldenNotifications covers each notification kind. The sensor name holds
+// the characters chat formats have to escape.
+func goldenNotifications() map[string]notification {
+	sensor := func(status string) models.Sensor {
+		s := models.Sensor{Name: "Roof <north> & yard", Status: status, LastSeenAt: &goldenTime}
+		s.ID = 42
+		return s
+	}
+	fire := &Warning{ID: 5, Event: Event{En: "Risk of fire", Sv: "Brandrisk", Code: "FIRE"}}
+	wind := &Warning{ID: 6, Event: Event{En: "Strong wind", Sv: "Kraftig vind", Code: "WIND"},
+		AreaName: AreaName{En: "Baltic coast", Sv: "Östersjökusten"}}
+
+	inside := areaMatch{
+		indexedArea: &indexedArea{Warning: fire, Area: &WarningArea{
+			ID:               1,
+			AreaName:         AreaName{En: "Gotland", Sv: "Gotland"},
+			ApproximateStart: "2025-01-14T06:00:00Z",
+			ApproximateEnd:   "2025-01-15T18:00:00Z",
+			WarningLevel:     WarningLevel{Code: "RED"},
+			Descriptions: []Description{{Text: Text{
+				En: "Very high risk of grass fires, see <https://www.smhi.se> for advice",
+				Sv: "Mycket stor risk för gräsbränder, se <https://www.smhi.se> för råd",
+			}}},
+		}},
+		Proximity: proximityInside,
+	}
+	near := areaMatch{
+		indexedArea: &indexedArea{Warning: wind, Area: &WarningArea{
+			ID:               2,
+			ApproximateStart: "2025-01-14T09:30:00Z",
+			WarningLevel:     WarningLevel{Code: "ORANGE"},
+		}},
+		Proximity:  proximityNear,
+		DistanceKm: 12.34,
+	}
+
+	rule := models.AlertRule{Name: "Frost & ice", Metric: "temperature", Comparator: comparatorLT, Threshold: 0.5}
+	rule.ID = 3
+	state := models.AlertRuleState{LastValue: -1.25, TriggeredAt: &goldenTime}
+
+	notifications := map[string]notification{
+		"warning":      warningNotification(sensor(statusRed), inside, []lifecycleKind{lifecycleNew}),
+		"warning_near": warningNotification(sensor(statusOrange), near, []lifecycleKind{lifecycleEscalated, lifecycleTimeChanged}),
+		"resolved":     resolvedNotification(sensor(statusYellow), statusRed, "FIRE"),
+		"offline":      newNotification(sensor(statusOffline), sensorPayload(sensor(statusOffline), notificationOffline)),
+		"online":       newNotification(sensor(statusOrange), sensorPayload(sensor(statusOrange), notificationOnline)),
+		"threshold":    newNotification(sensor(statusOK), thresholdPayload(sensor(statusOK), rule, state, ruleTriggered)),
+	}
+	for _, n := range notifications {
+		n.Payload["timestamp"] = goldenTime
+	}
+	return notifications
+}
+
+// TestRenderPayloadGolden renders every notification kind in every format
+// and language and compares the result with testdata/formats. Run the test
+// with -update after an intended change and review the diff.
+func TestRenderPayloadGolden(t *testing.T) {
+	notifications := goldenNotifications()
+	for _, format := range []string{formatGeneric, formatSlack, formatTeams, formatDiscord} {
+		for _, language := range []string{languageEnglish, languageSwedish} {
+			if format == formatGeneric && language == languageSwedish {
+				continue // Generic payloads are not translated
+			}
+			name := format + "_" + language
+			t.Run(name, func(t *testing.T) {
+				rendered := make(map[string]interface{}, len(notifications))
+				for kind, n := range notifications {
+					payload := renderPayload(n, format, language)
+					if format == formatDiscord {
+						payload["embeds"].([]interface{})[0].(map[string]interface{})["timestamp"] = goldenTime.Format(time.RFC3339)
+					}
+					rendered[kind] = payload
+				}
+				// Unescaped HTML keeps the Slack entities readable in the files
+				var buf bytes.Buffer
+				encoder := json.NewEncoder(&buf)
+				encoder.SetEscapeHTML(false)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(rendered); err != nil {
+					t.Fatalf("marshal: %v", err)
+				}
+				got := buf.Bytes()
+
+				path := filepath.Join("testdata", "formats", name+".json")
+				if *updateGolden {
+					if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(path, got, 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("read golden file, run with -update to create it: %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s differs from the rendered payloads, got:\n%s", path, got)
+				}
+			})
+		}
+	}
+}
+
+func TestGenericPayloadSchemaVersion(t *testing.T) {
+	for kind, n := range goldenNotifications() {
+		for _, format := range []string{"", formatGeneric} {
+			payload := renderPayload(n, format, languageSwedish)
+			if got := payload["schema_version"]; got != webhookSchemaVersion {
+				t.Errorf("%s in format %q: schema_version = %v, want %d", kind, format, got, webhookSchemaVersion)
+			}
+			if _, ok := n.Payload["schema_version"]; ok {
+				t.Fatalf("%s: rendering modified the notification payload", kind)
+			}
+			for key, value := range n.Payload {
+				if key != "timestamp" && payload[key] == nil && value != nil {
+					t.Errorf("%s: field %s missing from the generic payload", kind, key)
+				}
+			}
+		}
+	}
+}
+
+func TestSlackPayloadEscapesMrkdwn(t *testing.T) {
+	for kind, n := range goldenNotifications() {
+		data, err := json.Marshal(renderPayload(n, formatSlack, languageEnglish))
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		var payload struct {
+			Text        string `json:"text"`
+			Attachments []struct {
+				Blocks []struct {
+					Type string `json:"type"`
+					Text struct {
+						Type string `json:"type"`
+						Text string `json:"text"`
+					} `json:"text"`
+					Fields []struct {
+						Text string `json:"text"`
+					} `json:"fields"`
+					Elements []struct {
+						Text string `json:"text"`
+					} `json:"elements"`
+				} `json:"blocks"`
+			} `json:"attachments"`
+		}
+		if err := json.Unmarshal(data, &payload); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+
+		texts := []string{payload.Text}
+		for _, block := range payload.Attachments[0].Blocks {
+			if block.Type == "header" {
+				if block.Text.Type != "plain_text" {
+					t.Errorf("%s: header is %s, want plain_text", kind, block.Text.Type)
+				}
+				continue
+			}
+			texts = append(texts, block.Text.Text)
+			for _, field := range block.Fields {
+				texts = append(texts, field.Text)
+			}
+			for _, element := range block.Elements {
+				texts = append(texts, element.Text)
+			}
+		}
+		entities := strings.NewReplacer("&amp;", "", "&lt;", "", "&gt;", "")
+		for _, text := range texts {
+			if strings.ContainsAny(entities.Replace(text), "&<>") {
+				t.Errorf("%s: unescaped mrkdwn %q", kind, text)
+			}
+		}
+	}
+}
+
+func TestStatusName(t *testing.T) {
+	tests := []struct {
+		status, language, want string
+	}{
+		{statusOK, languageSwedish, "OK"},
+		{statusYellow, languageSwedish, "Gul"},
+		{statusOrange, languageEnglish, "Orange"},
+		{statusRed, languageSwedish, "Röd"},
+		{statusOffline, languageSwedish, "Offline"},
+		{statusMaintenance, languageSwedish, "Underhåll"},
+	}
+	for _, tt := range tests {
+		if got := statusName(tt.status, tt.language); got != tt.want {
+			t.Errorf("statusName(%s, %s) = %q, want %q", tt.status, tt.language, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// earthRadiusKm is the mean Earth radius used for distance calculations.
+const earthRadiusKm = 6371.0
+
+// point is a GeoJSON position. SMHI, like GeoJSON, orders coordinates as
+// [longitude, latitude].
+type point struct {
+	Lon float64
+	Lat float64
+}
+
+// ring is a closed linear ring. The first and last points are usually equal,
+// but the containment test does not depend on it.
+type ring []point
+
+// polygon holds an exterior ring followed by zero or more holes.
+type polygon []ring
+
+// multiPolygon is the common shape every warning area is decoded into, a
+// single Polygon becomes a multiPolygon of length one.
+type multiPolygon []polygon
+
+// decodeGeometry turns the untyped coordinates of a warning area into a
+// multiPolygon. Only Polygon and MultiPolygon geometries are supported.
+func decodeGeometry(area GeoJSONFeature) (multiPolygon, error) {
+	switch area.Geometry.Type {
+	case "Polygon":
+		poly, err := decodePolygon(area.Geometry.Coordinates)
+		if err != nil {
+			return nil, err
+		}
+		return multiPolygon{poly}, nil
+	case "MultiPolygon":
+		raw, ok := area.Geometry.Coordinates.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("multipolygon coordinates: expected array, got %T", area.Geometry.Coordinates)
+		}
+		polys := make(multiPolygon, 0, len(raw))
+		for i, r := range raw {
+			poly, err := decodePolygon(r)
+			if err != nil {
+				return nil, fmt.Errorf("polygon %d: %w", i, err)
+			}
+			polys = append(polys, poly)
+		}
+		return polys, nil
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", area.Geometry.Type)
+	}
+}
+
+func decodePolygon(v interface{}) (polygon, error) {
+	raw, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("polygon coordinates: expected array, got %T", v)
+	}
+	poly := make(polygon, 0, len(raw))
+	for i, r := range raw {
+		rg, err := decodeRing(r)
+		if err != nil {
+			return nil, fmt.Errorf("ring %d: %w", i, err)
+		}
+		poly = append(poly, rg)
+	}
+	return poly, nil
+}
+
+func decodeRing(v interface{}) (ring, error) {
+	raw, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("ring coordinates: expected array, got %T", v)
+	}
+	if len(raw) < 3 {
+		return nil, fmt.Errorf("ring has %d positions, need at least 3", len(raw))
+	}
+	rg := make(ring, 0, len(raw))
+	for i, r := range raw {
+		pos, ok := r.([]interface{})
+		if !ok || len(pos) < 2 {
+			return nil, fmt.Errorf("position %d: expected [lon, lat]", i)
+		}
+		lon, okLon := pos[0].(float64)
+		lat, okLat := pos[1].(float64)
+		if !okLon || !okLat {
+			return nil, fmt.Errorf("position %d: non-numeric coordinate", i)
+		}
+		rg = append(rg, point{Lon: lon, Lat: lat})
+	}
+	return rg, nil
+}
+
+// boundaryTolerance is how close in degrees, roughly a millimetre, a point
+// must be to an edge to count as lying on it.
+const boundaryTolerance = 1e-8
+
+// locate reports in one pass whether the point lies inside the ring, using
+// the even-odd ray casting rule with longitude as x and latitude as y, and
+// whether it lies on one of the ring's edges. Ray casting alone classifies
+// points on an edge arbitrarily.
+func (rg ring) locate(lat, lon float64) (inside, onEdge bool) {
+	for i, j := 0, len(rg)-1; i < len(rg); j, i = i, i+1 {
+		a, b := rg[i], rg[j]
+		if (a.Lat > lat) != (b.Lat > lat) &&
+			lon < (b.Lon-a.Lon)*(lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+
+		// Cheap rejection on the edge's bounding box before the exact test
+		if (lat < a.Lat-boundaryTolerance && lat < b.Lat-boundaryTolerance) ||
+			(lat > a.Lat+boundaryTolerance && lat > b.Lat+boundaryTolerance) ||
+			(lon < a.Lon-boundaryTolerance && lon < b.Lon-boundaryTolerance) ||
+			(lon > a.Lon+boundaryTolerance && lon > b.Lon+boundaryTolerance) {
+			continue
+		}
+		// Distance from the point to the line through a and b
+		cross := (b.Lon-a.Lon)*(lat-a.Lat) - (b.Lat-a.Lat)*(lon-a.Lon)
+		if math.Abs(cross) <= boundaryTolerance*math.Hypot(b.Lon-a.Lon, b.Lat-a.Lat) {
+			return true, true
+		}
+	}
+	return inside, false
+}
+
+// contains reports whether the point lies inside the exterior ring and
+// outside every hole. Points on an edge belong to the polygon, whether the
+// edge is part of the exterior ring or of a hole, so a sensor on the border
+// of a warning area is always warned.
+func (p polygon) contains(lat, lon float64) bool {
+	if len(p) == 0 {
+		return false
+	}
+	if inside, onEdge := p[0].locate(lat, lon); !inside {
+		return false
+	} else if onEdge {
+		return true
+	}
+	for _, hole := range p[1:] {
+		if inside, onEdge := hole.locate(lat, lon); inside && !onEdge {
+			return false
+		}
+	}
+	return true
+}
+
+func (mp multiPolygon) contains(lat, lon float64) bool {
+	for _, p := range mp {
+		if p.contains(lat, lon) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSensorInArea reports whether a sensor position falls within a warning area.
+func isSensorInArea(lat, long float64, area GeoJSONFeature) bool {
+	geom, err := decodeGeometry(area)
+	if err != nil {
+		log.Printf("Error decoding warning area geometry: %v", err)
+		return false
+	}
+	return geom.contains(lat, long)
+}
+
+// distanceKm returns the distance from a position outside the geometry to
+// its closest edge. Edges are projected onto a plane centred on the position,
+// which is accurate to well under a percent at the alert radii used for
+// proximity warnings.
+func (mp multiPolygon) distanceKm(lat, lon float64) float64 {
+	kmPerDegLat := earthRadiusKm * math.Pi / 180
+	kmPerDegLon := kmPerDegLat * math.Cos(lat*math.Pi/180)
+
+	// Squared distances are compared and the root taken once at the end
+	best := math.Inf(1)
+	for _, p := range mp {
+		for _, rg := range p {
+			if len(rg) == 0 {
+				continue
+			}
+			prev := rg[len(rg)-1]
+			bx, by := (prev.Lon-lon)*kmPerDegLon, (prev.Lat-lat)*kmPerDegLat
+			for _, pt := range rg {
+				ax, ay := bx, by
+				bx, by = (pt.Lon-lon)*kmPerDegLon, (pt.Lat-lat)*kmPerDegLat
+				// Skip edges whose bounding box is already farther than the best edge
+				if nx, ny := nearestOffset(ax, bx), nearestOffset(ay, by); nx*nx+ny*ny >= best {
+					continue
+				}
+				if d := segmentDistanceSq(ax, ay, bx, by); d < best {
+					best = d
+				}
+			}
+		}
+	}
+	return math.Sqrt(best)
+}
+
+// nearestOffset returns the distance from 0 to the interval between a and b.
+func nearestOffset(a, b float64) float64 {
+	switch {
+	case a > 0 && b > 0:
+		return min(a, b)
+	case a < 0 && b < 0:
+		return -max(a, b)
+	default:
+		return 0
+	}
+}
+
+// segmentDistanceSq returns the squared distance from the origin to the
+// segment a-b.
+func segmentDistanceSq(ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = -(ax*dx + ay*dy) / l
+		if t < 0 {
+			t = 0
+		} else if t > 1 {
+			t = 1
+		}
+	}
+	x, y := ax+t*dx, ay+t*dy
+	return x*x + y*y
+}
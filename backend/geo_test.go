@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Warning area shapes in the form SMHI publishes them, with the coastlines
+// simplified to a handful of vertices.
+const (
+	// Gotland, a single Polygon
+	gotlandArea = `{"type":"Feature","properties":{},"geometry":{"type":"Polygon","coordinates":
+		[[[18.05,57.25],[18.20,56.90],[18.80,57.25],[19.35,57.95],[18.70,57.90],[18.10,57.70],[18.05,57.25]]]}}`
+
+	// Västra Götaland with Vänern cut out as a hole
+	vastraGotalandArea = `{"type":"Feature","properties":{},"geometry":{"type":"Polygon","coordinates":[
+		[[11.2,57.7],[12.0,57.3],[13.9,57.5],[14.2,58.4],[13.6,59.3],[12.3,59.2],[11.3,58.9],[11.2,57.7]],
+		[[12.4,58.4],[13.2,58.4],[13.9,58.8],[13.5,59.2],[12.6,59.1],[12.4,58.4]]]}}`
+
+	// Öland and Gotland as one MultiPolygon, the Baltic between them is outside
+	olandGotlandArea = `{"type":"Feature","properties":{},"geometry":{"type":"MultiPolygon","coordinates":[
+		[[[16.40,56.20],[16.60,56.20],[17.10,57.35],[16.90,57.37],[16.40,56.20]]],
+		[[[18.05,57.25],[18.20,56.90],[18.80,57.25],[19.35,57.95],[18.70,57.90],[18.10,57.70],[18.05,57.25]]]]}}`
+)
+
+func decodeFeature(t *testing.T, raw string) GeoJSONFeature {
+	t.Helper()
+	var feature GeoJSONFeature
+	if err := json.Unmarshal([]byte(raw), &feature); err != nil {
+		t.Fatalf("unmarshal feature: %v", err)
+	}
+	return feature
+}
+
+func TestIsSensorInArea(t *testing.T) {
+	tests := []struct {
+		name     string
+		area     string
+		lat, lon float64
+		want     bool
+	}{
+		{"Visby inside Gotland", gotlandArea, 57.63, 18.29, true},
+		{"Stockholm outside Gotland", gotlandArea, 59.33, 18.07, false},
+		{"inside bounding box but outside Gotland", gotlandArea, 57.0, 18.05, false},
+		{"Alingsås inside Västra Götaland", vastraGotalandArea, 57.93, 12.53, true},
+		{"Vänern in the hole", vastraGotalandArea, 58.7, 13.1, false},
+		{"on the shore of the hole", vastraGotalandArea, 58.4, 12.8, true},
+		{"Öland part of the MultiPolygon", olandGotlandArea, 56.88, 16.80, true},
+		{"Gotland part of the MultiPolygon", olandGotlandArea, 57.63, 18.29, true},
+		{"between the parts of the MultiPolygon", olandGotlandArea, 57.2, 17.6, false},
+		{"on an edge", gotlandArea, 57.075, 18.50, true},
+		{"on a vertex", gotlandArea, 57.95, 19.35, true},
+		{"just outside an edge", gotlandArea, 57.070, 18.51, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSensorInArea(tt.lat, tt.lon, decodeFeature(t, tt.area)); got != tt.want {
+				t.Errorf("isSensorInArea(%v, %v) = %v, want %v", tt.lat, tt.lon, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeGeometryErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		area string
+	}{
+		{"string coordinates", `{"geometry":{"type":"Polygon","coordinates":[[["18.0","57.0"],["18.5","57.0"],["18.5","57.5"]]]}}`},
+		{"position with one number", `{"geometry":{"type":"Polygon","coordinates":[[[18.0],[18.5,57.0],[18.5,57.5]]]}}`},
+		{"ring with two positions", `{"geometry":{"type":"Polygon","coordinates":[[[18.0,57.0],[18.5,57.0]]]}}`},
+		{"coordinates not an array", `{"geometry":{"type":"Polygon","coordinates":57.0}}`},
+		{"ring not an array", `{"geometry":{"type":"Polygon","coordinates":[57.0]}}`},
+		{"missing coordinates", `{"geometry":{"type":"MultiPolygon"}}`},
+		{"MultiPolygon with a bad part", `{"geometry":{"type":"MultiPolygon","coordinates":[
+			[[[18.0,57.0],[18.5,57.0],[18.5,57.5],[18.0,57.0]]],
+			[[[18.0,57.0],[null,57.0],[18.5,57.5],[18.0,57.0]]]]}}`},
+		{"unsupported type", `{"geometry":{"type":"Point","coordinates":[18.0,57.0]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feature := decodeFeature(t, tt.area)
+			if _, err := decodeGeometry(feature); err == nil {
+				t.Fatal("decodeGeometry succeeded, want an error")
+			}
+			if isSensorInArea(57.2, 18.2, feature) {
+				t.Error("isSensorInArea matched a malformed area")
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// defaultOfflineAfter is used when SENSOR_OFFLINE_AFTER is not set.
+	defaultOfflineAfter = 30 * time.Minute
+	// offlineCheckInterval is how often silent sensors are looked for.
+	offlineCheckInterval = time.Minute
+)
+
+// touchSensor records that a sensor was heard from. A sensor that was
+// OFFLINE goes back to the status its active warnings call for, OK if there
+// are none, and its company is notified.
+func touchSensor(sensorID uint, now time.Time) {
+	var sensor models.Sensor
+	result := db.Model(&sensor).Clauses(clause.Returning{}).
+		Where("id = ?", sensorID).
+		UpdateColumn("last_seen_at", now)
+	if result.Error != nil {
+		log.Printf("Error updating last seen for sensor %d: %v", sensorID, result.Error)
+		return
+	}
+	if sensor.Status != statusOffline {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		status, detail, err := currentWeatherStatus(tx, sensor, now)
+		if err != nil {
+			return err
+		}
+		changed, err := transitionSensorStatus(tx, &sensor, status, reasonOnline, detail)
+		if err != nil || !changed {
+			return err
+		}
+		log.Printf("Sensor %d is back online", sensorID)
+		return notifySensor(tx, sensor, sensorPayload(sensor, notificationOnline))
+	})
+	if err != nil {
+		log.Printf("Error bringing sensor %d back online: %v", sensorID, err)
+	}
+}
+
+// markOfflineSensors moves sensors that have been silent for longer than
+// offlineAfter to OFFLINE and notifies their companies. Sensors that have
+// never reported are left alone.
+func markOfflineSensors(offlineAfter time.Duration, now time.Time) {
+	var silent []models.Sensor
+	err := db.Where("last_seen_at IS NOT NULL AND last_seen_at < ? AND status <> ?", now.Add(-offlineAfter), statusOffline).
+		Find(&silent).Error
+	if err != nil {
+		log.Printf("Error fetching silent sensors: %v", err)
+		return
+	}
+	for _, sensor := range silent {
+		detail := "last seen " + sensor.LastSeenAt.Format(time.RFC3339)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			changed, err := transitionSensorStatus(tx, &sensor, statusOffline, reasonOffline, detail)
+			if err != nil || !changed {
+				return err
+			}
+			log.Printf("Sensor %d went offline, %s", sensor.ID, detail)
+			return notifySensor(tx, sensor, sensorPayload(sensor, notificationOffline))
+		})
+		if err != nil {
+			log.Printf("Error marking sensor %d offline: %v", sensor.ID, err)
+		}
+	}
+}
+
+// periodicOfflineCheck runs markOfflineSensors until the process exits.
+func periodicOfflineCheck(offlineAfter time.Duration) {
+	ticker := time.NewTicker(offlineCheckInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		markOfflineSensors(offlineAfter, now)
+	}
+}
+
+// DeviceHeartbeatHandler lets a device report that it is alive without
+// sending readings.
+func DeviceHeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sensor, err := getDeviceSensor(r.Context())
+	if err != nil {
+		http.Error(w, "Sensor not found", http.StatusNotFound)
+		return
+	}
+	touchSensor(sensor.ID, time.Now())
+	w.WriteHeader(http.StatusNoContent)
+}
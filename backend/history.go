@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"gorm.io/gorm"
+)
+
+// parseSMHITime parses an SMHI timestamp, returning nil when it is empty or
+// malformed.
+func parseSMHITime(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// recordWarningHistory writes a fetched snapshot and its lifecycle events to
+// the database. Every warning in the snapshot has its LastSeen bumped, and
+// every event upserts the affected area and appends a revision unless the
+// area is unchanged since its latest revision.
+func recordWarningHistory(warnings []Warning, events []lifecycleEvent, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		records := make(map[int]*models.WarningRecord)
+		for _, warning := range warnings {
+			record, err := upsertWarningRecord(tx, warning, now)
+			if err != nil {
+				return err
+			}
+			records[warning.ID] = record
+		}
+
+		for _, event := range events {
+			record, ok := records[event.Key.WarningID]
+			if !ok {
+				var err error
+				if record, err = upsertWarningRecord(tx, event.Warning, now); err != nil {
+					return err
+				}
+				records[event.Key.WarningID] = record
+			}
+			if err := recordAreaEvent(tx, record, event, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func upsertWarningRecord(tx *gorm.DB, warning Warning, now time.Time) (*models.WarningRecord, error) {
+	var record models.WarningRecord
+	err := tx.Where("smhi_id = ?", warning.ID).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		record = models.WarningRecord{SMHIID: warning.ID, FirstSeen: now}
+	} else if err != nil {
+		return nil, err
+	}
+
+	record.EventCode = warning.Event.Code
+	record.EventEn = warning.Event.En
+	record.EventSv = warning.Event.Sv
+	record.AreaNameEn = warning.AreaName.En
+	record.AreaNameSv = warning.AreaName.Sv
+	record.LastSeen = now
+	if err := tx.Save(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func recordAreaEvent(tx *gorm.DB, warning *models.WarningRecord, event lifecycleEvent, now time.Time) error {
+	geometry, err := json.Marshal(event.Area.Area.Geometry)
+	if err != nil {
+		return err
+	}
+
+	var area models.WarningAreaRecord
+	err = tx.Where("warning_record_id = ? AND smhi_id = ?", warning.ID, event.Area.ID).First(&area).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		area = models.WarningAreaRecord{WarningRecordID: warning.ID, SMHIID: event.Area.ID}
+	} else if err != nil {
+		return err
+	}
+
+	area.Level = parseSeverity(event.Area.WarningLevel.Code).String()
+	area.ApproximateStart = parseSMHITime(event.Area.ApproximateStart)
+	area.ApproximateEnd = parseSMHITime(event.Area.ApproximateEnd)
+	area.Published = event.Area.Published
+	area.Geometry = geometry
+	area.EndedAt = nil
+	if event.Kind.ended() {
+		area.EndedAt = &now
+	}
+	if err := tx.Omit("AffectedAreas", "Revisions").Save(&area).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("warning_area_record_id = ?", area.ID).Delete(&models.AffectedAreaRecord{}).Error; err != nil {
+		return err
+	}
+	for _, affected := range event.Area.AffectedAreas {
+		row := models.AffectedAreaRecord{WarningAreaRecordID: area.ID, SMHIID: affected.ID, Sv: affected.Sv, En: affected.En}
+		if err := tx.Create(&row).Error; err != nil {
+			return err
+		}
+	}
+
+	revision := models.WarningAreaRevision{
+		WarningAreaRecordID: area.ID,
+		Kind:                string(event.Kind),
+		Level:               area.Level,
+		ApproximateStart:    area.ApproximateStart,
+		ApproximateEnd:      area.ApproximateEnd,
+		Published:           area.Published,
+		Geometry:            geometry,
+	}
+
+	// A restart replays every active area as NEW, skip those that are already recorded
+	var latest models.WarningAreaRevision
+	err = tx.Where("warning_area_record_id = ?", area.ID).Order("id DESC").First(&latest).Error
+	if err == nil && !event.Kind.ended() && sameRevision(latest, revision) {
+		return nil
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return tx.Create(&revision).Error
+}
+
+func sameRevision(a, b models.WarningAreaRevision) bool {
+	return a.Level == b.Level &&
+		a.Published == b.Published &&
+		equalTime(a.ApproximateStart, b.ApproximateStart) &&
+		equalTime(a.ApproximateEnd, b.ApproximateEnd) &&
+		jsonEqual(a.Geometry, b.Geometry)
+}
+
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// jsonEqual compares two JSON documents after compaction, since jsonb does
+// not preserve the original formatting.
+func jsonEqual(a, b []byte) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// likeEscaper escapes the LIKE wildcards and the escape character itself.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes a user supplied string match literally in a LIKE pattern
+// with backslash as its escape character.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// weatherWarningHistoryHandler serves persisted warnings whose areas were
+// active within a time range, filtered by level, event code and affected area.
+func weatherWarningHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	if v := query.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid from timestamp", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if v := query.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid to timestamp", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	if to.Before(from) {
+		http.Error(w, "to must not be before from", http.StatusBadRequest)
+		return
+	}
+
+	page, _ := strconv.Atoi(query.Get("page"))
+	pageSize, _ := strconv.Atoi(query.Get("pageSize"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	q := db.Table("warning_area_records").
+		Joins("JOIN warning_records ON warning_records.id = warning_area_records.warning_record_id").
+		Where("warning_area_records.deleted_at IS NULL").
+		Where("COALESCE(warning_area_records.approximate_start, warning_area_records.created_at) <= ?", to).
+		Where("COALESCE(warning_area_records.approximate_end, warning_area_records.ended_at, NOW()) >= ?", from)
+
+	if level := query.Get("level"); level != "" {
+		s := parseSeverity(level)
+		if s == severityNone {
+			http.Error(w, "Level must be YELLOW, ORANGE or RED", http.StatusBadRequest)
+			return
+		}
+		q = q.Where("warning_area_records.level = ?", s.String())
+	}
+	if eventCode := query.Get("eventCode"); eventCode != "" {
+		q = q.Where("warning_records.event_code = ?", eventCode)
+	}
+	if areaName := query.Get("areaName"); areaName != "" {
+		q = q.Where(`EXISTS (SELECT 1 FROM affected_area_records a
+			WHERE a.warning_area_record_id = warning_area_records.id AND a.sv ILIKE ? ESCAPE '\')`,
+			"%"+escapeLike(areaName)+"%")
+	}
+
+	// The matching areas, reused for the count, the page and the preload
+	areas := q.Session(&gorm.Session{})
+
+	var total int64
+	if err := areas.Distinct("warning_area_records.warning_record_id").Count(&total).Error; err != nil {
+		log.Printf("Error counting warning history: %v", err)
+		http.Error(w, "Error fetching warning history", http.StatusInternalServerError)
+		return
+	}
+
+	// Paginate over warnings, keeping only the areas that matched
+	var warningIDs []uint
+	err := areas.Distinct("warning_area_records.warning_record_id").
+		Order("warning_area_records.warning_record_id DESC").
+		Limit(pageSize).
+		Offset((page-1)*pageSize).
+		Pluck("warning_area_records.warning_record_id", &warningIDs).Error
+	if err != nil {
+		log.Printf("Error querying warning history: %v", err)
+		http.Error(w, "Error fetching warning history", http.StatusInternalServerError)
+		return
+	}
+
+	warnings := []models.WarningRecord{}
+	if len(warningIDs) > 0 {
+		pageAreas := areas.Select("warning_area_records.id").Where("warning_area_records.warning_record_id IN ?", warningIDs)
+		err := db.Preload("Areas", func(tx *gorm.DB) *gorm.DB { return tx.Where("id IN (?)", pageAreas).Order("id") }).
+			Preload("Areas.AffectedAreas").
+			Preload("Areas.Revisions", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+			Where("id IN ?", warningIDs).
+			Order("id DESC").
+			Find(&warnings).Error
+		if err != nil {
+			log.Printf("Error loading warning history: %v", err)
+			http.Error(w, "Error fetching warning history", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := struct {
+		Warnings []models.WarningRecord `json:"warnings"`
+		Total    int64                  `json:"total"`
+	}{
+		Warnings: warnings,
+		Total:    total,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Västra Götaland", "Västra Götaland"},
+		{"100%", `100\%`},
+		{"area_1", `area\_1`},
+		{`C:\temp`, `C:\\temp`},
+		{`\%_`, `\\\%\_`},
+	}
+	for _, tt := range tests {
+		if got := escapeLike(tt.in); got != tt.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWeatherWarningHistoryHandlerRejectsBadRanges(t *testing.T) {
+	tests := []string{
+		"from=yesterday",
+		"to=2025-13-01T00:00:00Z",
+		"from=2025-02-01T00:00:00Z&to=2025-01-01T00:00:00Z",
+	}
+	for _, query := range tests {
+		w := httptest.NewRecorder()
+		weatherWarningHistoryHandler(w, httptest.NewRequest(http.MethodGet, "/api/warnings/history?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, w.Code)
+		}
+	}
+}
+
+// seedWarningHistory stores four warnings with areas active in January 2025
+// and one that ended in 2024.
+func seedWarningHistory(t *testing.T) {
+	t.Helper()
+	day := func(d int) *time.Time {
+		ts := time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC)
+		return &ts
+	}
+	ended := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	area := func(smhiID int, level string, start, end *time.Time, names ...string) models.WarningAreaRecord {
+		a := models.WarningAreaRecord{SMHIID: smhiID, Level: level, ApproximateStart: start, ApproximateEnd: end}
+		for i, name := range names {
+			a.AffectedAreas = append(a.AffectedAreas, models.AffectedAreaRecord{SMHIID: smhiID*10 + i, Sv: name, En: name})
+		}
+		return a
+	}
+	warnings := []models.WarningRecord{
+		{SMHIID: 1, EventCode: "FIRE", Areas: []models.WarningAreaRecord{
+			area(1, "RED", day(2), day(4), "Gotlands län"),
+		}},
+		{SMHIID: 2, EventCode: "WIND", Areas: []models.WarningAreaRecord{
+			area(2, "YELLOW", day(5), day(6), "Stockholms län"),
+			area(3, "ORANGE", day(5), day(7), "Uppsala län", "Gävleborgs län"),
+		}},
+		{SMHIID: 3, EventCode: "WIND", Areas: []models.WarningAreaRecord{
+			area(4, "ORANGE", day(10), day(12), "Skåne_län 100%"),
+		}},
+		{SMHIID: 4, EventCode: "RAIN", Areas: []models.WarningAreaRecord{
+			area(5, "YELLOW", day(20), day(21), "Västra Götalands län"),
+		}},
+		{SMHIID: 5, EventCode: "WIND", Areas: []models.WarningAreaRecord{
+			area(6, "RED", &ended, &ended, "Norrbottens län"),
+		}},
+	}
+	for i := range warnings {
+		warnings[i].FirstSeen, warnings[i].LastSeen = *day(1), *day(1)
+	}
+	if err := db.Create(&warnings).Error; err != nil {
+		t.Fatalf("seeding warnings: %v", err)
+	}
+}
+
+type historyResponse struct {
+	Warnings []models.WarningRecord `json:"warnings"`
+	Total    int64                  `json:"total"`
+}
+
+// getWarningHistory queries the history, over January 2025 unless the query
+// gives its own range.
+func getWarningHistory(t *testing.T, query string) (int, historyResponse) {
+	t.Helper()
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatalf("parsing query %q: %v", query, err)
+	}
+	if !params.Has("from") {
+		params.Set("from", "2025-01-01T00:00:00Z")
+		params.Set("to", "2025-01-31T00:00:00Z")
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/warnings/history?"+params.Encode(), nil)
+	weatherWarningHistoryHandler(w, r)
+	var response historyResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+	}
+	return w.Code, response
+}
+
+// TestWeatherWarningHistoryHandler needs TEST_DATABASE_URL.
+func TestWeatherWarningHistoryHandler(t *testing.T) {
+	openTestDB(t)
+	seedWarningHistory(t)
+
+	// Each warning is listed by SMHI ID followed by the SMHI IDs of its areas
+	tests := []struct {
+		name  string
+		query string
+		want  [][]int
+		total int64
+	}{
+		{"everything in range, newest first", "", [][]int{{4, 5}, {3, 4}, {2, 2, 3}, {1, 1}}, 4},
+		{"level", "level=orange", [][]int{{3, 4}, {2, 3}}, 2},
+		{"event code", "eventCode=WIND", [][]int{{3, 4}, {2, 2, 3}}, 2},
+		{"area name keeps only matching areas", "areaName=uppsala", [][]int{{2, 3}}, 1},
+		{"area name matched literally", "areaName=_l%C3%A4n+100%25", [][]int{{3, 4}}, 1},
+		{"wildcard is not a wildcard", "areaName=%25", [][]int{{3, 4}}, 1},
+		{"combined filters", "level=YELLOW&eventCode=WIND", [][]int{{2, 2}}, 1},
+		{"narrow range", "from=2025-01-05T12:00:00Z&to=2025-01-06T12:00:00Z", [][]int{{2, 2, 3}}, 1},
+		{"first page", "pageSize=3", [][]int{{4, 5}, {3, 4}, {2, 2, 3}}, 4},
+		{"second page", "pageSize=3&page=2", [][]int{{1, 1}}, 4},
+		{"past the last page", "pageSize=3&page=3", [][]int{}, 4},
+		{"page of a filtered list", "eventCode=WIND&pageSize=1&page=2", [][]int{{2, 2, 3}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, response := getWarningHistory(t, tt.query)
+			if code != http.StatusOK {
+				t.Fatalf("status %d", code)
+			}
+			got := [][]int{}
+			for _, warning := range response.Warnings {
+				ids := []int{warning.SMHIID}
+				for _, area := range warning.Areas {
+					ids = append(ids, area.SMHIID)
+				}
+				got = append(got, ids)
+			}
+			if !reflect.DeepEqual(got, tt.want) || response.Total != tt.total {
+				t.Errorf("got %v total %d, want %v total %d", got, response.Total, tt.want, tt.total)
+			}
+		})
+	}
+
+	if code, _ := getWarningHistory(t, "level=PURPLE"); code != http.StatusBadRequest {
+		t.Errorf("unknown level: status %d, want 400", code)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"log"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+// indexCellSize is the edge length in degrees of a grid cell in the warning
+// index. SMHI areas range from a single municipality to whole counties, so a
+// quarter degree keeps both cell counts and candidates per cell small.
+const indexCellSize = 0.25
+
+type bbox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+func (b bbox) contains(lat, lon float64) bool {
+	return lon >= b.MinLon && lon <= b.MaxLon && lat >= b.MinLat && lat <= b.MaxLat
+}
+
+func (b bbox) intersects(o bbox) bool {
+	return b.MinLon <= o.MaxLon && o.MinLon <= b.MaxLon && b.MinLat <= o.MaxLat && o.MinLat <= b.MaxLat
+}
+
+func (mp multiPolygon) bounds() bbox {
+	b := bbox{MinLon: math.Inf(1), MinLat: math.Inf(1), MaxLon: math.Inf(-1), MaxLat: math.Inf(-1)}
+	for _, p := range mp {
+		if len(p) == 0 {
+			continue
+		}
+		// Holes lie within the exterior ring, so it alone bounds the polygon.
+		for _, pt := range p[0] {
+			b.MinLon = math.Min(b.MinLon, pt.Lon)
+			b.MinLat = math.Min(b.MinLat, pt.Lat)
+			b.MaxLon = math.Max(b.MaxLon, pt.Lon)
+			b.MaxLat = math.Max(b.MaxLat, pt.Lat)
+		}
+	}
+	return b
+}
+
+// indexedArea is a warning area with its geometry decoded once at index time.
+type indexedArea struct {
+	Warning *Warning
+	Area    *WarningArea
+	geom    multiPolygon
+	bounds  bbox
+}
+
+type cellKey struct {
+	X, Y int
+}
+
+// areaKey identifies a warning area across fetches.
+type areaKey struct {
+	WarningID int `json:"warning_id"`
+	AreaID    int `json:"area_id"`
+}
+
+// warningIndex is a uniform grid over the bounding boxes of all cached warning
+// areas. It is rebuilt whenever cachedWarnings is refreshed and is read-only
+// afterwards, so it can be shared between goroutines without locking.
+type warningIndex struct {
+	areas  []indexedArea
+	cells  map[cellKey][]int
+	byArea map[areaKey]int
+	// stored is set once the areas are written to warning_area_geometries,
+	// PostGIS matching is only used for an index whose areas are.
+	stored bool
+}
+
+func cellOf(lat, lon float64) cellKey {
+	return cellKey{X: int(math.Floor(lon / indexCellSize)), Y: int(math.Floor(lat / indexCellSize))}
+}
+
+// buildWarningIndex decodes every warning area and registers it in each grid
+// cell its bounding box touches. Areas with undecodable geometry are skipped.
+func buildWarningIndex(warnings []Warning) *warningIndex {
+	idx := &warningIndex{cells: make(map[cellKey][]int), byArea: make(map[areaKey]int)}
+	for i := range warnings {
+		warning := &warnings[i]
+		for j := range warning.WarningAreas {
+			area := &warning.WarningAreas[j]
+			geom, err := decodeGeometry(area.Area)
+			if err != nil {
+				log.Printf("Skipping warning %d area %d: %v", warning.ID, area.ID, err)
+				continue
+			}
+			b := geom.bounds()
+			if math.IsInf(b.MinLon, 0) {
+				continue
+			}
+			idx.areas = append(idx.areas, indexedArea{Warning: warning, Area: area, geom: geom, bounds: b})
+			pos := len(idx.areas) - 1
+			idx.byArea[areaKey{WarningID: warning.ID, AreaID: area.ID}] = pos
+
+			lo, hi := cellOf(b.MinLat, b.MinLon), cellOf(b.MaxLat, b.MaxLon)
+			for x := lo.X; x <= hi.X; x++ {
+				for y := lo.Y; y <= hi.Y; y++ {
+					k := cellKey{X: x, Y: y}
+					idx.cells[k] = append(idx.cells[k], pos)
+				}
+			}
+		}
+	}
+	return idx
+}
+
+// Proximity of a sensor to a warning area.
+const (
+	proximityInside = "inside"
+	proximityNear   = "near"
+)
+
+// areaMatch is a warning area that applies to a sensor, either because the
+// sensor is inside it or because it lies within the sensor's alert radius.
+type areaMatch struct {
+	*indexedArea
+	Proximity  string
+	DistanceKm float64
+}
+
+// query returns every indexed warning area that contains the given position
+// or lies within radiusKm of it.
+func (idx *warningIndex) query(lat, lon, radiusKm float64) []areaMatch {
+	if idx == nil || len(idx.areas) == 0 {
+		return nil
+	}
+
+	// Widen the search window by the radius, converted to degrees at this latitude.
+	dLat := radiusKm / (earthRadiusKm * math.Pi / 180)
+	dLon := dLat / math.Max(math.Cos(lat*math.Pi/180), 0.01)
+	window := bbox{MinLon: lon - dLon, MinLat: lat - dLat, MaxLon: lon + dLon, MaxLat: lat + dLat}
+
+	var matches []areaMatch
+	// An area spanning several cells is met once per cell. Only a handful of
+	// areas overlap any one window, so a slice beats allocating a map per query.
+	var seen []int
+	lo, hi := cellOf(window.MinLat, window.MinLon), cellOf(window.MaxLat, window.MaxLon)
+	for x := lo.X; x <= hi.X; x++ {
+		for y := lo.Y; y <= hi.Y; y++ {
+			for _, pos := range idx.cells[cellKey{X: x, Y: y}] {
+				if slices.Contains(seen, pos) {
+					continue
+				}
+				seen = append(seen, pos)
+
+				a := &idx.areas[pos]
+				if !a.bounds.intersects(window) {
+					continue
+				}
+				if a.bounds.contains(lat, lon) && a.geom.contains(lat, lon) {
+					matches = append(matches, areaMatch{indexedArea: a, Proximity: proximityInside})
+					continue
+				}
+				if radiusKm <= 0 {
+					continue
+				}
+				if d := a.geom.distanceKm(lat, lon); d <= radiusKm {
+					matches = append(matches, areaMatch{indexedArea: a, Proximity: proximityNear, DistanceKm: d})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// lookup returns the indexed area with the given key, or nil if it is unknown.
+func (idx *warningIndex) lookup(key areaKey) *indexedArea {
+	if idx == nil {
+		return nil
+	}
+	pos, ok := idx.byArea[key]
+	if !ok {
+		return nil
+	}
+	return &idx.areas[pos]
+}
+
+// alertRadiusKm returns the proximity radius for a sensor, preferring its
+// own setting over the company default.
+func alertRadiusKm(sensor *models.Sensor, companies map[uint]models.Company) float64 {
+	if sensor.AlertRadiusKm > 0 {
+		return sensor.AlertRadiusKm
+	}
+	return companies[sensor.CompanyID].AlertRadiusKm
+}
+
+// matchSensors returns the warning areas that apply to each sensor, keyed by
+// sensor ID. In PostGIS mode the database does the matching and the in-memory
+// index is only used when that query fails, or when storing the areas failed
+// and the table still holds an earlier fetch.
+func matchSensors(sensors []models.Sensor, companies map[uint]models.Company, index *warningIndex) map[uint][]areaMatch {
+	if postgisEnabled && index.stored {
+		matches, err := matchSensorsPostGIS(index)
+		if err == nil {
+			return matches
+		}
+		log.Printf("PostGIS matching failed, falling back to in-process matcher: %v", err)
+	}
+	return matchSensorsInProcess(sensors, companies, index)
+}
+
+// matchSensorsInProcess matches sensors against the in-memory index only.
+// The fleet is split across one goroutine per CPU, the index is read-only so
+// they share it without locking.
+func matchSensorsInProcess(sensors []models.Sensor, companies map[uint]models.Company, index *warningIndex) map[uint][]areaMatch {
+	found := make([][]areaMatch, len(sensors))
+	workers := min(runtime.GOMAXPROCS(0), len(sensors)/1000+1)
+	chunk := (len(sensors) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(sensors); start += chunk {
+		end := min(start+chunk, len(sensors))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				sensor := &sensors[i]
+				found[i] = index.query(sensor.Latitude, sensor.Longitude, alertRadiusKm(sensor, companies))
+			}
+		}()
+	}
+	wg.Wait()
+
+	matches := make(map[uint][]areaMatch)
+	for i, areas := range found {
+		if len(areas) > 0 {
+			matches[sensors[i].ID] = areas
+		}
+	}
+	return matches
+}
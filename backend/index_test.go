@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+// benchmarkSensors is the fleet size matching has to handle well under a second.
+const benchmarkSensors = 100_000
+
+// syntheticWarnings scatters count irregular warning areas of 64 vertices
+// over Sweden, from municipality to county size.
+func syntheticWarnings(rng *rand.Rand, count int) []Warning {
+	levels := []string{"YELLOW", "ORANGE", "RED"}
+	warnings := make([]Warning, 0, count)
+	for i := 0; i < count; i++ {
+		lat, lon := 55.5+rng.Float64()*13, 11.5+rng.Float64()*12
+		radius := 0.1 + rng.Float64()*1.2
+
+		const vertices = 64
+		ring := make([]interface{}, 0, vertices+1)
+		for v := 0; v < vertices; v++ {
+			angle := 2 * math.Pi * float64(v) / vertices
+			r := radius * (0.6 + 0.4*rng.Float64())
+			ring = append(ring, []interface{}{lon + 2*r*math.Cos(angle), lat + r*math.Sin(angle)})
+		}
+		ring = append(ring, ring[0])
+
+		area := WarningArea{ID: i + 1, WarningLevel: WarningLevel{Code: levels[rng.Intn(len(levels))]}}
+		area.Area.Type = "Feature"
+		area.Area.Geometry.Type = "Polygon"
+		area.Area.Geometry.Coordinates = []interface{}{ring}
+		warnings = append(warnings, Warning{ID: i + 1, WarningAreas: []WarningArea{area}})
+	}
+	return warnings
+}
+
+func syntheticSensors(rng *rand.Rand, count int) []models.Sensor {
+	sensors := make([]models.Sensor, count)
+	for i := range sensors {
+		sensors[i].ID = uint(i + 1)
+		sensors[i].CompanyID = uint(i%50 + 1)
+		sensors[i].Status = statusOK
+		sensors[i].Latitude = 55.3 + rng.Float64()*13.8
+		sensors[i].Longitude = 11 + rng.Float64()*13
+	}
+	return sensors
+}
+
+func TestMatchSensorsInProcessAgreesWithBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	warnings := syntheticWarnings(rng, 40)
+	sensors := syntheticSensors(rng, 5000)
+	matches := matchSensorsInProcess(sensors, nil, buildWarningIndex(warnings))
+
+	for _, sensor := range sensors {
+		want := 0
+		for _, warning := range warnings {
+			for _, area := range warning.WarningAreas {
+				if isSensorInArea(sensor.Latitude, sensor.Longitude, area.Area) {
+					want++
+				}
+			}
+		}
+		if got := len(matches[sensor.ID]); got != want {
+			t.Fatalf("sensor %d at %v,%v: index found %d areas, brute force %d",
+				sensor.ID, sensor.Latitude, sensor.Longitude, got, want)
+		}
+	}
+}
+
+func BenchmarkBuildWarningIndex(b *testing.B) {
+	warnings := syntheticWarnings(rand.New(rand.NewSource(1)), 200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buildWarningIndex(warnings)
+	}
+}
+
+// BenchmarkMatchSensors covers the in-memory part of a processing cycle for
+// a 100k sensor fleet: matching against the index and planning the updates.
+func BenchmarkMatchSensors(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	warnings := syntheticWarnings(rng, 200)
+	sensors := syntheticSensors(rng, benchmarkSensors)
+	companies := make(map[uint]models.Company)
+	for id := uint(1); id <= 50; id++ {
+		companies[id] = models.Company{AlertRadiusKm: 10}
+	}
+	index := buildWarningIndex(warnings)
+	now := time.Now()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matches := matchSensorsInProcess(sensors, companies, index)
+		planWarningUpdates(sensors, companies, nil, nil, matches, nil, now)
+	}
+}
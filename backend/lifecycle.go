@@ -0,0 +1,178 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// lifecycleKind describes how a warning area changed between two fetches.
+type lifecycleKind string
+
+const (
+	lifecycleNew             lifecycleKind = "NEW"
+	lifecycleGeometryChanged lifecycleKind = "GEOMETRY_CHANGED"
+	lifecycleEscalated       lifecycleKind = "ESCALATED"
+	lifecycleDowngraded      lifecycleKind = "DOWNGRADED"
+	lifecycleTimeChanged     lifecycleKind = "TIME_CHANGED"
+	lifecycleCancelled       lifecycleKind = "CANCELLED"
+	lifecycleExpired         lifecycleKind = "EXPIRED"
+)
+
+// ended reports whether the lifecycle kind means the area no longer applies.
+func (k lifecycleKind) ended() bool {
+	return k == lifecycleCancelled || k == lifecycleExpired
+}
+
+// lifecycleEvent is a single transition of a warning area. Warning and Area
+// hold the latest known state, which for ended areas is the last snapshot
+// they appeared in.
+type lifecycleEvent struct {
+	Kind     lifecycleKind `json:"kind"`
+	Key      areaKey       `json:"key"`
+	Warning  Warning       `json:"-"`
+	Area     WarningArea   `json:"-"`
+	Previous *WarningArea  `json:"-"`
+}
+
+type trackedArea struct {
+	Warning Warning
+	Area    WarningArea
+}
+
+// warningTracker remembers which warning areas were active at the last fetch
+// so each new snapshot can be turned into lifecycle events.
+type warningTracker struct {
+	mu     sync.Mutex
+	active map[areaKey]trackedArea
+}
+
+var tracker = &warningTracker{active: make(map[areaKey]trackedArea)}
+
+// areaActive reports whether a warning area has not yet reached its
+// approximate end. Areas without a parseable end time are treated as active.
+func areaActive(area WarningArea, now time.Time) bool {
+	if area.ApproximateEnd == "" {
+		return true
+	}
+	end, err := time.Parse(time.RFC3339, area.ApproximateEnd)
+	if err != nil {
+		return true
+	}
+	return now.Before(end)
+}
+
+// diff compares a new snapshot with the areas that were active at the last
+// call, records the new state and returns the resulting events ordered by
+// warning and area ID.
+func (t *warningTracker) diff(warnings []Warning, now time.Time) []lifecycleEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var events []lifecycleEvent
+	next := make(map[areaKey]trackedArea)
+	present := make(map[areaKey]bool)
+
+	for _, warning := range warnings {
+		for _, area := range warning.WarningAreas {
+			key := areaKey{WarningID: warning.ID, AreaID: area.ID}
+			present[key] = true
+			if !areaActive(area, now) {
+				continue
+			}
+			next[key] = trackedArea{Warning: warning, Area: area}
+
+			prev, ok := t.active[key]
+			if !ok {
+				events = append(events, lifecycleEvent{Kind: lifecycleNew, Key: key, Warning: warning, Area: area})
+				continue
+			}
+			for _, kind := range areaChanges(prev.Area, area) {
+				previous := prev.Area
+				events = append(events, lifecycleEvent{Kind: kind, Key: key, Warning: warning, Area: area, Previous: &previous})
+			}
+		}
+	}
+
+	for key, prev := range t.active {
+		if _, ok := next[key]; ok {
+			continue
+		}
+		kind := lifecycleCancelled
+		if present[key] {
+			kind = lifecycleExpired
+		}
+		events = append(events, lifecycleEvent{Kind: kind, Key: key, Warning: prev.Warning, Area: prev.Area})
+	}
+
+	t.active = next
+
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i].Key, events[j].Key
+		if a.WarningID != b.WarningID {
+			return a.WarningID < b.WarningID
+		}
+		return a.AreaID < b.AreaID
+	})
+	return events
+}
+
+// areaChanges lists the kinds of change between two revisions of an area.
+func areaChanges(prev, next WarningArea) []lifecycleKind {
+	var kinds []lifecycleKind
+	if !reflect.DeepEqual(prev.Area.Geometry, next.Area.Geometry) {
+		kinds = append(kinds, lifecycleGeometryChanged)
+	}
+	prevLevel, nextLevel := parseSeverity(prev.WarningLevel.Code), parseSeverity(next.WarningLevel.Code)
+	switch {
+	case nextLevel > prevLevel:
+		kinds = append(kinds, lifecycleEscalated)
+	case nextLevel < prevLevel:
+		kinds = append(kinds, lifecycleDowngraded)
+	}
+	if prev.ApproximateStart != next.ApproximateStart || prev.ApproximateEnd != next.ApproximateEnd {
+		kinds = append(kinds, lifecycleTimeChanged)
+	}
+	return kinds
+}
+
+// endedWarnings rebuilds a warning list from the ended events so the areas
+// can be indexed and matched against sensors one last time.
+func endedWarnings(events []lifecycleEvent) []Warning {
+	byID := make(map[int]int)
+	var warnings []Warning
+	for _, event := range events {
+		if !event.Kind.ended() {
+			continue
+		}
+		pos, ok := byID[event.Key.WarningID]
+		if !ok {
+			warning := event.Warning
+			warning.WarningAreas = nil
+			warnings = append(warnings, warning)
+			pos = len(warnings) - 1
+			byID[event.Key.WarningID] = pos
+		}
+		warnings[pos].WarningAreas = append(warnings[pos].WarningAreas, event.Area)
+	}
+	return warnings
+}
+
+// refreshSummary describes the outcome of one fetch-and-notify cycle.
+type refreshSummary struct {
+	WarningCount int                   `json:"warning_count"`
+	Counts       map[lifecycleKind]int `json:"counts"`
+	Events       []lifecycleEvent      `json:"events"`
+}
+
+func summarizeEvents(warningCount int, events []lifecycleEvent) refreshSummary {
+	summary := refreshSummary{WarningCount: warningCount, Counts: make(map[lifecycleKind]int), Events: events}
+	if summary.Events == nil {
+		summary.Events = []lifecycleEvent{}
+	}
+	for _, event := range events {
+		summary.Counts[event.Kind]++
+	}
+	return summary
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+// A fetch failure reprocesses the cached snapshot, which has to expire the
+// areas whose end has passed even though nothing new was fetched.
+func TestTrackerExpiresCachedWarnings(t *testing.T) {
+	warnings := []Warning{{ID: 7, WarningAreas: []WarningArea{
+		{ID: 1, WarningLevel: WarningLevel{Code: "ORANGE"}, ApproximateEnd: "2025-01-14T18:00:00Z"},
+		{ID: 2, WarningLevel: WarningLevel{Code: "YELLOW"}},
+	}}}
+	tracker := &warningTracker{active: make(map[areaKey]trackedArea)}
+
+	events := tracker.diff(warnings, time.Date(2025, 1, 14, 12, 0, 0, 0, time.UTC))
+	if len(events) != 2 || events[0].Kind != lifecycleNew || events[1].Kind != lifecycleNew {
+		t.Fatalf("first diff = %+v, want two NEW events", events)
+	}
+	if events := tracker.diff(warnings, time.Date(2025, 1, 14, 13, 0, 0, 0, time.UTC)); len(events) != 0 {
+		t.Fatalf("unchanged diff = %+v, want no events", events)
+	}
+
+	events = tracker.diff(warnings, time.Date(2025, 1, 14, 18, 0, 0, 0, time.UTC))
+	if len(events) != 1 || events[0].Kind != lifecycleExpired || events[0].Key != (areaKey{WarningID: 7, AreaID: 1}) {
+		t.Fatalf("diff after the end = %+v, want area 1 EXPIRED", events)
+	}
+	if events := tracker.diff(warnings, time.Date(2025, 1, 14, 19, 0, 0, 0, time.UTC)); len(events) != 0 {
+		t.Fatalf("diff after expiry = %+v, want no events", events)
+	}
+}
+
+// The resolved notification names the warning that had raised the sensor,
+// whether that warning ended or was downgraded.
+func TestPlanWarningUpdatesRaisedBy(t *testing.T) {
+	now := time.Date(2025, 1, 14, 12, 0, 0, 0, time.UTC)
+	gotland := func(id int, level, eventCode string) Warning {
+		area := WarningArea{ID: 1, Area: decodeFeature(t, gotlandArea), WarningLevel: WarningLevel{Code: level}}
+		return Warning{ID: id, Event: Event{Code: eventCode}, WarningAreas: []WarningArea{area}}
+	}
+	visby := func() []models.Sensor {
+		sensors := []models.Sensor{{Status: statusRed, Latitude: 57.63, Longitude: 18.29}}
+		sensors[0].ID = 1
+		return sensors
+	}
+
+	t.Run("ended", func(t *testing.T) {
+		fire, wind := gotland(1, "RED", "FIRE"), gotland(2, "YELLOW", "WIND")
+		events := []lifecycleEvent{{
+			Kind: lifecycleExpired, Key: areaKey{WarningID: 1, AreaID: 1},
+			Warning: fire, Area: fire.WarningAreas[0],
+		}}
+		sensors := visby()
+		index := buildWarningIndex([]Warning{wind})
+		updates := planWarningUpdates(sensors, nil, nil, nil, matchSensorsInProcess(sensors, nil, index), events, now)
+		if len(updates) != 1 || updates[0].raisedBy == nil {
+			t.Fatalf("updates = %+v, want one with raisedBy", updates)
+		}
+		if got := updates[0].raisedBy.Warning.Event.Code; got != "FIRE" {
+			t.Errorf("raised by %s, want FIRE", got)
+		}
+	})
+
+	t.Run("downgraded", func(t *testing.T) {
+		fire, wind := gotland(1, "YELLOW", "FIRE"), gotland(2, "YELLOW", "WIND")
+		previous := fire.WarningAreas[0]
+		previous.WarningLevel.Code = "RED"
+		events := []lifecycleEvent{{
+			Kind: lifecycleDowngraded, Key: areaKey{WarningID: 1, AreaID: 1},
+			Warning: fire, Area: fire.WarningAreas[0], Previous: &previous,
+		}}
+		sensors := visby()
+		index := buildWarningIndex([]Warning{wind, fire})
+		updates := planWarningUpdates(sensors, nil, nil, nil, matchSensorsInProcess(sensors, nil, index), events, now)
+		if len(updates) != 1 || updates[0].raisedBy == nil {
+			t.Fatalf("updates = %+v, want one with raisedBy", updates)
+		}
+		if got := updates[0].raisedBy.Warning.Event.Code; got != "FIRE" {
+			t.Errorf("raised by %s, want FIRE", got)
+		}
+	})
+
+	t.Run("not lowered", func(t *testing.T) {
+		fire := gotland(1, "RED", "FIRE")
+		sensors := visby()
+		index := buildWarningIndex([]Warning{fire})
+		events := []lifecycleEvent{{
+			Kind: lifecycleGeometryChanged, Key: areaKey{WarningID: 1, AreaID: 1},
+			Warning: fire, Area: fire.WarningAreas[0],
+		}}
+		updates := planWarningUpdates(sensors, nil, nil, nil, matchSensorsInProcess(sensors, nil, index), events, now)
+		if len(updates) != 1 || updates[0].raisedBy != nil {
+			t.Fatalf("updates = %+v, want one without raisedBy", updates)
+		}
+	})
+}
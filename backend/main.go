@@ -12,7 +12,6 @@ import (
 	"sync"
 	"time"
 
-	"bytes"
 	"context"
 
 	"github.com/Rohnson95/weathermonitoring/backend/models"
@@ -34,12 +33,18 @@ type SensorRequest struct {
     Latitude float64 `json:"latitude"`
     Longitude float64 `json:"longitude"`
     Description string `json:"description"`
+    AlertRadiusKm float64 `json:"alert_radius_km"`
+    Group string `json:"group"`
+    Tags []string `json:"tags"`
 }
 // Struct for WebhookRequests
 type Claims struct {
     UserID uint `json:"user_id"`
     jwt.RegisteredClaims
 }
+// defaultPollInterval is used when WARNINGS_POLL_INTERVAL is not set
+const defaultPollInterval = 15 * time.Minute
+
 type RegisterRequest struct {
     Email    string `json:"email"`
     Password string `json:"password"`
@@ -155,9 +160,18 @@ type AffectedArea struct {
 var(
     db *gorm.DB
     cachedWarnings []Warning
+    cachedIndex *warningIndex
     warningsMutex sync.Mutex
+    refreshMutex sync.Mutex
 )
 
+// migratedModels are the tables created or updated at startup
+var migratedModels = []interface{}{&models.User{}, &models.Company{}, &models.Webhook{}, &models.Sensor{},
+    &models.WarningRecord{}, &models.WarningAreaRecord{}, &models.AffectedAreaRecord{}, &models.WarningAreaRevision{},
+    &models.WarningSnapshot{}, &models.Reading{}, &models.SensorKey{}, &models.AlertRule{}, &models.AlertRuleState{},
+    &models.ReadingRollup{}, &models.SensorStatusChange{}, &models.MaintenanceWindow{},
+    &models.WebhookDelivery{}, &models.WebhookAttempt{}}
+
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
     var req RegisterRequest
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -330,6 +344,11 @@ func handleGetSensors(w http.ResponseWriter, r *http.Request, userID uint) {
         return
     }
 
+    // Sensors in an active maintenance window are listed as MAINTENANCE
+    if err := applyMaintenanceStatus(sensors, time.Now()); err != nil {
+        log.Printf("Error checking maintenance windows: %v", err)
+    }
+
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusOK) // Changed from http.StatusCreated to http.StatusOK
     json.NewEncoder(w).Encode(sensors)
@@ -341,6 +360,10 @@ func handleCreateSensor(w http.ResponseWriter, r *http.Request, userID uint){
         http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
         return
     }
+    if err := validateSensorRequest(req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
 
     // Get user's company
     var user models.User
@@ -354,11 +377,20 @@ func handleCreateSensor(w http.ResponseWriter, r *http.Request, userID uint){
         Latitude: req.Latitude,
         Longitude: req.Longitude,
         Description: req.Description,
-        Status: "OK", // Default status
+        AlertRadiusKm: req.AlertRadiusKm,
+        Group: req.Group,
+        Tags: encodeList(req.Tags),
+        Status: statusOK, // Default status
         CompanyID: user.CompanyID,
     }
 
-    if err := db.Create(&sensor).Error; err != nil {
+    err := db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&sensor).Error; err != nil {
+            return err
+        }
+        return recordInitialStatus(tx, sensor)
+    })
+    if err != nil {
         http.Error(w, "Error creating sensor", http.StatusInternalServerError)
         return
     }
@@ -381,6 +413,10 @@ func handleUpdateSensor(w http.ResponseWriter, r *http.Request, userID uint){
         http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
         return
     }
+    if err := validateSensorRequest(req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
 
     var user models.User
     if err := db.First(&user, userID).Error; err != nil {
@@ -400,8 +436,21 @@ func handleUpdateSensor(w http.ResponseWriter, r *http.Request, userID uint){
     sensor.Latitude = req.Latitude
     sensor.Longitude = req.Longitude
     sensor.Description = req.Description
-
-    if err := db.Save(&sensor).Error; err != nil {
+    sensor.AlertRadiusKm = req.AlertRadiusKm
+    sensor.Group = req.Group
+    sensor.Tags = encodeList(req.Tags)
+
+    // Only the editable fields are written, Status and InMaintenance belong to
+    // transitionSensorStatus and writing back the values read above would
+    // revert a concurrent transition without recording it.
+    err := db.Model(&sensor).
+        Select("Name", "Latitude", "Longitude", "Description", "AlertRadiusKm", "Group", "Tags").
+        Updates(&sensor).Error
+    if err != nil {
+        http.Error(w, "Error updating sensor", http.StatusInternalServerError)
+        return
+    }
+    if err := db.First(&sensor, sensor.ID).Error; err != nil {
         http.Error(w, "Error updating sensor", http.StatusInternalServerError)
         return
     }
@@ -428,10 +477,75 @@ func handleDeleteSensor(w http.ResponseWriter, r *http.Request, userID uint){
     w.WriteHeader(http.StatusNoContent)
 }
 
+// CompanyHandler
+
+type CompanySettingsRequest struct {
+    AlertRadiusKm *float64 `json:"alert_radius_km"`
+    MinWarningLevel *string `json:"min_warning_level"`
+}
+
+func CompanyHandler(w http.ResponseWriter, r *http.Request){
+    userID, err := getUserIDFromContext(r.Context())
+    if err != nil{
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    var user models.User
+    if err := db.Preload("Company").First(&user, userID).Error; err != nil {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+
+    switch r.Method{
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(user.Company)
+    case http.MethodPut:
+        handleUpdateCompanySettings(w, r, user.Company)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func handleUpdateCompanySettings(w http.ResponseWriter, r *http.Request, company models.Company){
+    var req CompanySettingsRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
+        return
+    }
+
+    // Only update the settings present in the request
+    if req.AlertRadiusKm != nil {
+        if *req.AlertRadiusKm < 0 {
+            http.Error(w, "Alert radius cannot be negative", http.StatusBadRequest)
+            return
+        }
+        company.AlertRadiusKm = *req.AlertRadiusKm
+    }
+    if req.MinWarningLevel != nil {
+        level := parseSeverity(*req.MinWarningLevel)
+        if level == severityNone {
+            http.Error(w, "Minimum warning level must be YELLOW, ORANGE or RED", http.StatusBadRequest)
+            return
+        }
+        company.MinWarningLevel = level.String()
+    }
+    if err := db.Save(&company).Error; err != nil {
+        http.Error(w, "Error updating company", http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(company)
+}
+
 // WebhookHandler
 
 type WebhookRequest struct {
     URL string `json:"url"`
+    Format string `json:"format"` // generic (default), slack, teams or discord
+    Language string `json:"language"` // en (default) or sv, used by the chat formats
+    WebhookFilterRequest
 }
 
 func WebhookHandler(w http.ResponseWriter, r *http.Request){
@@ -446,6 +560,8 @@ func WebhookHandler(w http.ResponseWriter, r *http.Request){
         handleGetWebhooks(w, r, userID)
     case http.MethodPost:
         handleCreateWebhook(w, r, userID)
+    case http.MethodPut:
+        handleUpdateWebhook(w, r, userID)
     case http.MethodDelete:
         handleDeleteWebhook(w, r, userID)
 
@@ -472,16 +588,104 @@ func handleCreateWebhook(w http.ResponseWriter, r *http.Request, userID uint){
         return
     }
 
+    var user models.User
+    if err := db.First(&user, userID).Error; err != nil {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+    if err := req.validate(user.CompanyID); errors.Is(err, errFilterLookup) {
+        log.Printf("Error validating webhook filters: %v", err)
+        http.Error(w, "Error validating webhook filters", http.StatusInternalServerError)
+        return
+    } else if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err := validateFormat(req.Format, req.Language); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    secret, err := generateWebhookSecret()
+    if err != nil {
+        http.Error(w, "Error creating Webhook", http.StatusInternalServerError)
+        return
+    }
+
     webhook := models.Webhook{
         URL: req.URL,
         UserID: userID,
+        Secret: secret,
+        Format: formatGeneric,
+        Language: languageEnglish,
+    }
+    if req.Format != "" {
+        webhook.Format = req.Format
+    }
+    if req.Language != "" {
+        webhook.Language = req.Language
     }
+    req.apply(&webhook)
 
     if err := db.Create(&webhook).Error; err != nil {
         http.Error(w, "Error creating Webhook", http.StatusInternalServerError)
         return
     }
     w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(webhookResponse{Webhook: webhook, Secret: secret})
+}
+
+func handleUpdateWebhook(w http.ResponseWriter, r *http.Request, userID uint){
+    webhookID := r.URL.Query().Get("id")
+    if webhookID == "" {
+        http.Error(w, "Missing webhook ID", http.StatusBadRequest)
+        return
+    }
+
+    var req WebhookRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
+        return
+    }
+
+    var user models.User
+    if err := db.First(&user, userID).Error; err != nil {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+    if err := req.validate(user.CompanyID); errors.Is(err, errFilterLookup) {
+        log.Printf("Error validating webhook filters: %v", err)
+        http.Error(w, "Error validating webhook filters", http.StatusInternalServerError)
+        return
+    } else if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err := validateFormat(req.Format, req.Language); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    var webhook models.Webhook
+    if err := db.Where("id = ? AND user_id = ?", webhookID, userID).First(&webhook).Error; err != nil {
+        http.Error(w, "Webhook not found", http.StatusNotFound)
+        return
+    }
+
+    if req.URL != "" {
+        webhook.URL = req.URL
+    }
+    if req.Format != "" {
+        webhook.Format = req.Format
+    }
+    if req.Language != "" {
+        webhook.Language = req.Language
+    }
+    req.apply(&webhook)
+    if err := db.Save(&webhook).Error; err != nil {
+        http.Error(w, "Error updating Webhook", http.StatusInternalServerError)
+        return
+    }
     json.NewEncoder(w).Encode(webhook)
 }
 
@@ -499,140 +703,344 @@ func handleDeleteWebhook(w http.ResponseWriter, r *http.Request, userID uint){
     w.WriteHeader(http.StatusNoContent)
 }
 
+// setCachedWarnings replaces the cached warnings and rebuilds the spatial index over them
+func setCachedWarnings(warnings []Warning) *warningIndex {
+    index := buildWarningIndex(warnings)
+
+    if postgisEnabled {
+        if err := storeWarningGeometries(index); err != nil {
+            log.Printf("Error storing warning geometries, matching in-process until the next refresh: %v", err)
+        } else {
+            index.stored = true
+        }
+    }
+
+    warningsMutex.Lock()
+    cachedWarnings = warnings
+    cachedIndex = index
+    warningsMutex.Unlock()
+
+    return index
+}
+
+// refreshWarnings fetches a new snapshot, diffs it against the previous one
+// and notifies affected sensors about every lifecycle transition. When the fetch
+// fails the cached snapshot is processed instead, so warnings still expire and
+// sensor statuses come back down while the provider is unavailable
+func refreshWarnings() (refreshSummary, error) {
+    // Serialize refreshes so the ticker and the admin endpoint never diff or process concurrently,
+    // two processing runs would race on the same sensor status transitions
+    refreshMutex.Lock()
+    defer refreshMutex.Unlock()
+    return refreshWarningsLocked()
+}
+
+// tryRefreshWarnings runs a refresh unless one is already in progress, in
+// which case ok is false and nothing is fetched
+func tryRefreshWarnings() (summary refreshSummary, ok bool, err error) {
+    if !refreshMutex.TryLock() {
+        return refreshSummary{}, false, nil
+    }
+    defer refreshMutex.Unlock()
+    summary, err = refreshWarningsLocked()
+    return summary, true, err
+}
+
+// refreshWarningsLocked is refreshWarnings for a caller holding refreshMutex
+func refreshWarningsLocked() (refreshSummary, error) {
+    now := time.Now()
+    warnings, fetchErr := fetchWeatherWarnings()
+    if fetchErr != nil {
+        warningsMutex.Lock()
+        cached, index := cachedWarnings, cachedIndex
+        warningsMutex.Unlock()
+
+        // Only areas that ran out can change, the cached warnings were not seen again
+        events := tracker.diff(cached, now)
+        if err := recordWarningHistory(nil, events, now); err != nil {
+            log.Printf("Error recording warning history: %v", err)
+        }
+        processWarningsAndNotify(index, events)
+        return refreshSummary{}, fetchErr
+    }
+
+    events := tracker.diff(warnings, now)
+    if err := recordWarningHistory(warnings, events, now); err != nil {
+        log.Printf("Error recording warning history: %v", err)
+    }
+    index := setCachedWarnings(warnings)
+
+    log.Printf("Fetched and cached %d warnings, %d lifecycle events", len(warnings), len(events))
+
+    // process warnings and send webhook notifications, still holding the lock
+    processWarningsAndNotify(index, events)
+    return summarizeEvents(len(warnings), events), nil
+}
+
 // Webhook notification logic
-func periodicWeatherUpdate(){
-    ticker := time.NewTicker(15 * time.Minute)
+func periodicWeatherUpdate(interval time.Duration){
+    ticker := time.NewTicker(interval)
     defer ticker.Stop()
 
     for {
         select {
         case <-ticker.C:
-            warnings, err := fetchWeatherWarnings()
-            if err != nil {
+            if _, err := refreshWarnings(); err != nil {
                 log.Printf("Error fetching weather warnings: %v", err)
-                continue
             }
+        }
+    }
+}
 
-            warningsMutex.Lock()
-            cachedWarnings = warnings
-            warningsMutex.Unlock()
+// warningBatchSize is how many sensors share one status update transaction
+const warningBatchSize = 1000
 
-            log.Printf("Fetched and cached %d warnings", len(warnings))
+func processWarningsAndNotify(index *warningIndex, events []lifecycleEvent) {
+    // Runs every cycle, even without events, so statuses recover once warnings end
 
-            // process warnings and send webhook notifications
-            go processWarningsAndNotify(warnings)
+    // Load every sensor once and match each one against the index
+    var sensors []models.Sensor
+    if err := db.Find(&sensors).Error; err != nil {
+        log.Printf("Error fetching sensors: %v", err)
+        return
+    }
+
+    webhooksByCompany, err := loadCompanyWebhooks()
+    if err != nil {
+        log.Printf("Error fetching webhooks: %v", err)
+        return
+    }
+
+    var companies []models.Company
+    if err := db.Find(&companies).Error; err != nil {
+        log.Printf("Error fetching companies: %v", err)
+        return
+    }
+    companiesByID := make(map[uint]models.Company, len(companies))
+    for _, company := range companies {
+        companiesByID[company.ID] = company
+    }
+
+    now := time.Now()
+    maintenance, err := activeMaintenance(sensors, now)
+    if err != nil {
+        log.Printf("Error fetching maintenance windows: %v", err)
+        return
+    }
+
+    matches := matchSensors(sensors, companiesByID, index)
+    updates := planWarningUpdates(sensors, companiesByID, webhooksByCompany, maintenance, matches, events, now)
+    for start := 0; start < len(updates); start += warningBatchSize {
+        batch := updates[start:min(start+warningBatchSize, len(updates))]
+        if err := applyWarningUpdates(batch); err != nil {
+            log.Printf("Error updating %d sensors: %v", len(batch), err)
         }
     }
 }
 
-func processWarningsAndNotify(warnings []Warning) {
-    // Iterate through warnings to find orange or red
-    for _, warning := range warnings {
-        level := strings.ToUpper(warning.Event.Code) // Assuming code represents the level
-        if level != "ORANGE" && level != "RED" {
+// sensorUpdate is the status and the notifications worked out for one sensor
+type sensorUpdate struct {
+    sensor   *models.Sensor
+    level    severity
+    cause    *areaMatch
+    raisedBy *areaMatch // The area that set the status being lowered, if any
+    notify   []notifiedMatch
+    webhooks []models.Webhook
+}
+
+// planWarningUpdates works out, without touching the database, which sensors change status
+// or have warning transitions to be notified about
+func planWarningUpdates(sensors []models.Sensor, companiesByID map[uint]models.Company, webhooksByCompany map[uint][]models.Webhook,
+    maintenance map[uint]*models.MaintenanceWindow, matches map[uint][]areaMatch, events []lifecycleEvent, now time.Time) []sensorUpdate {
+    // Group the transitions by area, ended areas are matched against their last known geometry
+    changes := make(map[areaKey][]lifecycleKind)
+    previousAreas := make(map[areaKey]*WarningArea)
+    for _, event := range events {
+        changes[event.Key] = append(changes[event.Key], event.Kind)
+        if event.Previous != nil {
+            previousAreas[event.Key] = event.Previous
+        }
+    }
+    endedMatches := matchSensorsInProcess(sensors, companiesByID, buildWarningIndex(endedWarnings(events)))
+
+    var updates []sensorUpdate
+    for s := range sensors {
+        sensor := &sensors[s]
+        threshold := minSeverity(companiesByID[sensor.CompanyID].MinWarningLevel)
+        // Status changes are still recorded during maintenance, only notifications are held back
+        webhooks := webhooksByCompany[sensor.CompanyID]
+        if maintenance[sensor.ID] != nil {
+            webhooks = nil
+        }
+
+        effective, cause := effectiveWarningLevel(matches[sensor.ID], threshold, now)
+        var notify []notifiedMatch
+        for _, match := range matches[sensor.ID] {
+            level := parseSeverity(match.Area.WarningLevel.Code)
+            if level == severityNone || level < threshold || !areaActive(*match.Area, now) {
+                continue
+            }
+            // Notify only when the area changed since the last fetch
+            if kinds, ok := changes[areaKey{WarningID: match.Warning.ID, AreaID: match.Area.ID}]; ok {
+                notify = append(notify, notifiedMatch{match, kinds})
+            }
+        }
+        for _, match := range endedMatches[sensor.ID] {
+            if level := parseSeverity(match.Area.WarningLevel.Code); level == severityNone || level < threshold {
+                continue
+            }
+            notify = append(notify, notifiedMatch{match, changes[areaKey{WarningID: match.Warning.ID, AreaID: match.Area.ID}]})
+        }
+
+        if len(notify) == 0 && (!isWeatherStatus(sensor.Status) || sensor.Status == weatherStatus(effective)) {
             continue
         }
+        var raisedBy *areaMatch
+        if isWeatherStatus(sensor.Status) && effective < parseSeverity(sensor.Status) {
+            raisedBy = raisingMatch(parseSeverity(sensor.Status), previousAreas, matches[sensor.ID], endedMatches[sensor.ID])
+        }
+        updates = append(updates, sensorUpdate{sensor: sensor, level: effective, cause: cause, raisedBy: raisedBy, notify: notify, webhooks: webhooks})
+    }
+    return updates
+}
 
-        // For each warning area, find sensors within the area
-        for _, warningArea := range warning.WarningAreas {
-            // Fetch sensors in this area
-            // Implement spatial queries as needed
+// raisingMatch picks the area that set a sensor to level before this fetch, going by the level each
+// area had before it changed. Without an exact match the highest area is taken.
+func raisingMatch(level severity, previousAreas map[areaKey]*WarningArea, candidates ...[]areaMatch) *areaMatch {
+    var best *areaMatch
+    bestLevel := severityNone
+    for _, matches := range candidates {
+        for i := range matches {
+            area := matches[i].Area
+            if previous, ok := previousAreas[areaKey{WarningID: matches[i].Warning.ID, AreaID: area.ID}]; ok {
+                area = previous
+            }
+            areaLevel := parseSeverity(area.WarningLevel.Code)
+            if areaLevel == level {
+                return &matches[i]
+            }
+            if areaLevel > bestLevel {
+                best, bestLevel = &matches[i], areaLevel
+            }
+        }
+    }
+    return best
+}
 
-            // Fetch all companies (assuming multiple companies can have sensors)
-            var companies []models.Company
-            if err := db.Preload("Sensors").Find(&companies).Error; err != nil {
-                log.Printf("Error fetching companies: %v", err)
-                continue
+// applyWarningUpdates stores the status changes of a batch of sensors together with their notifications
+func applyWarningUpdates(updates []sensorUpdate) error {
+    return db.Transaction(func(tx *gorm.DB) error {
+        previous := make([]string, len(updates))
+        var transitions []statusTransition
+        for i := range updates {
+            previous[i] = updates[i].sensor.Status
+            if t, ok := weatherTransition(updates[i].sensor, updates[i].level, updates[i].cause); ok {
+                transitions = append(transitions, t)
             }
+        }
+        moved, err := applyStatusTransitions(tx, transitions)
+        if err != nil {
+            return err
+        }
 
-            for _, company := range companies {
-                for _, sensor := range company.Sensors {
-                    // Check if sensor is within the warning area
-                    // Implement point-in-polygon logic or use spatial extensions like PostGIS
-                    // For simplicity, let's assume we have a function isSensorInArea
-                    if isSensorInArea(sensor.Latitude, sensor.Longitude, warningArea.Area) {
-                        // Update sensor status
-                        sensor.Status = level
-                        db.Save(&sensor)
-
-                        // Find user webhooks associated with the company
-                        var webhooks []models.Webhook
-                        // Fetch all users in the company
-                        var users []models.User
-                        if err := db.Where("company_id = ?", company.ID).Find(&users).Error; err != nil {
-                            log.Printf("Error fetching users for company %d: %v", company.ID, err)
-                            continue
-                        }
-
-                        // Collect all webhooks for these users
-                        for _, user := range users {
-                            var userWebhooks []models.Webhook
-                            if err := db.Where("user_id = ?", user.ID).Find(&userWebhooks).Error; err != nil {
-                                log.Printf("Error fetching webhooks for user %d: %v", user.ID, err)
-                                continue
-                            }
-                            webhooks = append(webhooks, userWebhooks...)
-                        }
-
-                        // Send notifications
-                        for _, webhook := range webhooks {
-                            go sendWebhookNotification(webhook.URL, sensor, warning)
-                        }
-                    }
+        now := time.Now()
+        var deliveries []models.WebhookDelivery
+        for i, update := range updates {
+            var notifications []notification
+            for _, n := range update.notify {
+                notifications = append(notifications, warningNotification(*update.sensor, n.match, n.kinds))
+            }
+            if moved[update.sensor.ID] && parseSeverity(update.sensor.Status) < parseSeverity(previous[i]) {
+                eventCode := ""
+                if update.raisedBy != nil {
+                    eventCode = update.raisedBy.Warning.Event.Code
                 }
+                notifications = append(notifications, resolvedNotification(*update.sensor, previous[i], eventCode))
+            }
+            for _, n := range notifications {
+                rendered, err := newDeliveries(update.webhooks, n, now)
+                if err != nil {
+                    return err
+                }
+                deliveries = append(deliveries, rendered...)
             }
         }
-    }
+        return insertDeliveries(tx, deliveries)
+    })
 }
 
+// loadCompanyWebhooks groups all webhooks by the company of the user that owns them
+func loadCompanyWebhooks() (map[uint][]models.Webhook, error) {
+    var users []models.User
+    if err := db.Select("id", "company_id").Find(&users).Error; err != nil {
+        return nil, err
+    }
+    companyOf := make(map[uint]uint, len(users))
+    for _, user := range users {
+        companyOf[user.ID] = user.CompanyID
+    }
 
-func isSensorInArea(lat, long float64, area GeoJSONFeature) bool {
-    return false // placeholder
+    var webhooks []models.Webhook
+    if err := db.Find(&webhooks).Error; err != nil {
+        return nil, err
     }
+    byCompany := make(map[uint][]models.Webhook)
+    for _, webhook := range webhooks {
+        companyID, ok := companyOf[webhook.UserID]
+        if !ok {
+            continue
+        }
+        byCompany[companyID] = append(byCompany[companyID], webhook)
+    }
+    return byCompany, nil
+}
+
+// notifiedMatch is a warning area match to notify about, with the lifecycle changes that triggered it
+type notifiedMatch struct {
+    match areaMatch
+    kinds []lifecycleKind
+}
+
+// warningNotification wraps warningPayload with the level and event code webhook filters look at
+func warningNotification(sensor models.Sensor, match areaMatch, kinds []lifecycleKind) notification {
+    n := newNotification(sensor, warningPayload(sensor, match, kinds))
+    n.Level = parseSeverity(match.Area.WarningLevel.Code)
+    n.EventCode = match.Warning.Event.Code
+    n.Match = match
+    n.Changes = kinds
+    return n
+}
 
-func sendWebhookNotification(url string, sensor models.Sensor, warning Warning) {
-    payload := map[string]interface{}{
+// warningPayload builds the notification for a sensor affected by a warning area
+func warningPayload(sensor models.Sensor, match areaMatch, kinds []lifecycleKind) map[string]interface{} {
+    return map[string]interface{}{
+		"kind":        notificationWarning,
 		"sensor_id":   sensor.ID,
 		"sensor_name": sensor.Name,
 		"status":      sensor.Status,
-		"warning":     warning.Event.En,
+		"warning":     match.Warning.Event.En,
+		"warning_level": parseSeverity(match.Area.WarningLevel.Code).String(),
+		"proximity":   match.Proximity,
+		"distance_km": match.DistanceKm,
+		"events":      kinds,
 		"timestamp":   time.Now(),
 	}
-
-    data, err := json.Marshal(payload)
-    if err != nil{
-        log.Printf("Error marshalling payload: %v", err)
-        return
-    }
-
-    resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
-    if err != nil {
-        log.Printf("Error sending webhook notification: %v", err)
-        return
-    }
-    defer resp.Body.Close()
-    
-    if resp.StatusCode >= 300 {
-        log.Printf("webhook %s responded with status %d", url, resp.StatusCode)
-    }
 }
 
+
 func fetchWeatherWarnings() ([]Warning, error) {
-    url := "https://opendata-download-warnings.smhi.se/ibww/api/version/1/warning.json"
-    resp, err := http.Get(url)
+    warnings, err := warningProvider.FetchWarnings(context.Background())
+    recordFetchResult(warningProvider.Name(), len(warnings), err)
     if err != nil {
-        log.Printf("Error fetching warnings from SMHI: %v", err)
-        return nil, fmt.Errorf("failed to fetch warnings: %w", err)
+        log.Printf("Error fetching warnings from %s provider: %v", warningProvider.Name(), err)
+        return nil, err
     }
-    defer resp.Body.Close()
 
-    var warnings []Warning
-    if err := json.NewDecoder(resp.Body).Decode(&warnings); err != nil {
-        log.Printf("Error decoding warnings response: %v", err)
-        return nil, fmt.Errorf("failed to decode warnings: %w", err)
+    if err := saveWarningSnapshot(warnings, time.Now()); err != nil {
+        log.Printf("Error saving warnings snapshot: %v", err)
     }
 
-    log.Printf("Fetched %d warnings from SMHI", len(warnings))
+    log.Printf("Fetched %d warnings from %s provider", len(warnings), warningProvider.Name())
     for i, warning := range warnings {
         log.Printf("Warning %d: %+v", i, warning)
     }
@@ -747,12 +1155,59 @@ func main() {
 	smhiAPIURL := os.Getenv("SMHI_API_URL")
 	serverPort := os.Getenv("SERVER_PORT")
 	frontendURL := os.Getenv("FRONTEND_URL")
+	warningsProviderKind := os.Getenv("WARNINGS_PROVIDER")
+	warningsFilePath := os.Getenv("WARNINGS_FILE_PATH")
+	pollInterval := defaultPollInterval
+	offlineAfter := defaultOfflineAfter
+	if v := os.Getenv("READINGS_RETENTION"); v != "" {
+		readingsRetention, err = time.ParseDuration(v)
+		if err != nil || readingsRetention <= 0 {
+			log.Fatalf("Invalid READINGS_RETENTION %q", v)
+		}
+	}
+	if v := os.Getenv("ROLLUP_MINUTE_RETENTION"); v != "" {
+		minuteRollupRetention, err = time.ParseDuration(v)
+		if err != nil || minuteRollupRetention <= 0 {
+			log.Fatalf("Invalid ROLLUP_MINUTE_RETENTION %q", v)
+		}
+	}
+	if v := os.Getenv("SENSOR_OFFLINE_AFTER"); v != "" {
+		offlineAfter, err = time.ParseDuration(v)
+		if err != nil || offlineAfter <= 0 {
+			log.Fatalf("Invalid SENSOR_OFFLINE_AFTER %q", v)
+		}
+	}
+	if v := os.Getenv("WARNINGS_POLL_INTERVAL"); v != "" {
+		pollInterval, err = time.ParseDuration(v)
+		if err != nil || pollInterval <= 0 {
+			log.Fatalf("Invalid WARNINGS_POLL_INTERVAL %q", v)
+		}
+	}
+	webhookWorkers := defaultWebhookWorkers
+	if v := os.Getenv("WEBHOOK_WORKERS"); v != "" {
+		webhookWorkers, err = strconv.Atoi(v)
+		if err != nil || webhookWorkers < 1 {
+			log.Fatalf("Invalid WEBHOOK_WORKERS %q", v)
+		}
+	}
+	if v := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); v != "" {
+		webhookMaxAttempts, err = strconv.Atoi(v)
+		if err != nil || webhookMaxAttempts < 1 {
+			log.Fatalf("Invalid WEBHOOK_MAX_ATTEMPTS %q", v)
+		}
+	}
 
 	// Validate essential environment variables
-	if dbHost == "" || dbUser == "" || dbPassword == "" || dbName == "" || dbPort == "" || jwtSecret == "" || smhiAPIURL == "" || serverPort == "" || frontendURL == "" {
+	if dbHost == "" || dbUser == "" || dbPassword == "" || dbName == "" || dbPort == "" || jwtSecret == "" || serverPort == "" || frontendURL == "" {
 		log.Fatal("One or more required environment variables are missing.")
 	}
 
+	// Select the weather warnings source, SMHI unless configured otherwise
+	warningProvider, err = newWarningProvider(warningsProviderKind, smhiAPIURL, warningsFilePath)
+	if err != nil {
+		log.Fatalf("Failed to configure warnings provider: %v", err)
+	}
+
 	// Initialize JWT key
 	jwtKey = []byte(jwtSecret)
 
@@ -766,40 +1221,116 @@ func main() {
 	}
 
 	// Migrate all models
-	err = db.AutoMigrate(&models.User{}, &models.Company{}, &models.Webhook{}, &models.Sensor{})
+	err = db.AutoMigrate(migratedModels...)
 	if err != nil {
 		log.Fatalf("Failed to migrate DataBase: %v", err)
 	}
+	if err := ensureWebhookSecrets(); err != nil {
+		log.Fatalf("Failed to generate webhook secrets: %v", err)
+	}
+	if err := grantAdmins(parseAdminEmails(os.Getenv("ADMIN_EMAILS"))); err != nil {
+		log.Fatalf("Failed to apply ADMIN_EMAILS: %v", err)
+	}
+
+	// Optional PostGIS mode for sensor matching
+	if os.Getenv("POSTGIS_ENABLED") == "true" {
+		enablePostGIS()
+	}
 
 	mux := http.NewServeMux()
 
-	// Initial fetch of weather warnings
+	// Prime the tracker with the snapshot stored before the last shutdown, so the initial
+	// fetch reports what changed while the backend was down rather than every active warning
+	previous, fetchedAt, snapshotErr := loadWarningSnapshot()
+	if snapshotErr == nil {
+		tracker.diff(previous, fetchedAt)
+	}
+
+	// Initial fetch of weather warnings, falling back to the last stored snapshot
 	warnings, err := fetchWeatherWarnings()
 	if err != nil {
-		log.Fatalf("Error fetching initial weather warnings: %v", err)
+		log.Printf("Error fetching initial weather warnings, starting in degraded mode: %v", err)
+		if snapshotErr != nil {
+			log.Printf("No stored warnings snapshot available: %v", snapshotErr)
+		} else {
+			warnings = previous
+			markSnapshotSource(fetchedAt, len(warnings))
+			log.Printf("Serving stored snapshot from %s", fetchedAt.Format(time.RFC3339))
+		}
 	}
 
-	warningsMutex.Lock()
-	cachedWarnings = warnings
-	warningsMutex.Unlock()
+	// Process the initial snapshot so sensors already under a warning get their status,
+	// and changes made while the backend was down are notified
+	now := time.Now()
+	events := tracker.diff(warnings, now)
+	if err := recordWarningHistory(warnings, events, now); err != nil {
+		log.Printf("Error recording warning history: %v", err)
+	}
+	processWarningsAndNotify(setCachedWarnings(warnings), events)
 
-	log.Printf("Initial fetch: cached %d warnings", len(warnings))
+	log.Printf("Initial fetch: cached %d warnings, %d lifecycle events", len(warnings), len(events))
 
 	// Start periodic updates
-	go periodicWeatherUpdate()
+	go periodicWeatherUpdate(pollInterval)
+	go periodicOfflineCheck(offlineAfter)
+	go periodicMaintenanceCheck()
+	go periodicRollups()
+	go runWebhookDispatcher(webhookWorkers)
+
+	// Optional MQTT bridge for sensor telemetry and status
+	if brokerURL := os.Getenv("MQTT_BROKER_URL"); brokerURL != "" {
+		mqttCfg := mqttConfig{
+			BrokerURL:    brokerURL,
+			ClientID:     os.Getenv("MQTT_CLIENT_ID"),
+			Username:     os.Getenv("MQTT_USERNAME"),
+			Password:     os.Getenv("MQTT_PASSWORD"),
+			TopicPattern: os.Getenv("MQTT_TOPIC_PATTERN"),
+			QoS:          1,
+		}
+		if mqttCfg.ClientID == "" {
+			mqttCfg.ClientID = "weather-iot-backend"
+		}
+		if mqttCfg.TopicPattern == "" {
+			mqttCfg.TopicPattern = defaultMQTTTopicPattern
+		}
+		if _, err := startMQTTBridge(mqttCfg); err != nil {
+			log.Fatalf("Failed to start MQTT bridge: %v", err)
+		}
+	}
 
 	// Register all your routes without CORS (handled globally)
 	mux.HandleFunc("/api/register", RegisterHandler)
 	mux.HandleFunc("/api/login", LoginHandler)
 	mux.HandleFunc("/api/weather-warnings", weatherWarningHandler)
+	mux.HandleFunc("/api/weather-warnings/history", weatherWarningHistoryHandler)
+	mux.HandleFunc("/api/weather-warnings/status", weatherWarningStatusHandler)
 	mux.HandleFunc("/api/sensors", Authenticate(SensorHandler))
+	mux.HandleFunc("/api/sensors/import", Authenticate(SensorImportHandler))
+	mux.HandleFunc("/api/sensors/export", Authenticate(SensorExportHandler))
+	mux.HandleFunc("/api/sensors/{id}/readings", Authenticate(ReadingsHandler))
+	mux.HandleFunc("/api/sensors/{id}/readings/aggregate", Authenticate(AggregatesHandler))
+	mux.HandleFunc("/api/sensors/{id}/status-history", Authenticate(StatusHistoryHandler))
+	mux.HandleFunc("/api/sensors/{id}/keys", Authenticate(SensorKeysHandler))
+	mux.HandleFunc("DELETE /api/sensors/{id}/keys/{keyID}", Authenticate(RevokeSensorKeyHandler))
+	mux.HandleFunc("POST /api/sensors/{id}/keys/{keyID}/rotate", Authenticate(RotateSensorKeyHandler))
+	mux.HandleFunc("/api/device/readings", AuthenticateDevice(DeviceReadingsHandler))
+	mux.HandleFunc("/api/device/heartbeat", AuthenticateDevice(DeviceHeartbeatHandler))
 	mux.HandleFunc("/api/webhooks", Authenticate(WebhookHandler))
+	mux.HandleFunc("/api/webhooks/{id}/rotate-secret", Authenticate(RotateWebhookSecretHandler))
+	mux.HandleFunc("/api/webhooks/{id}/deliveries", Authenticate(WebhookDeliveriesHandler))
+	mux.HandleFunc("/api/webhooks/{id}/deliveries/{deliveryID}/redeliver", Authenticate(RedeliverWebhookHandler))
+	mux.HandleFunc("/api/webhooks/dead-letters", Authenticate(DeadLettersHandler))
+	mux.HandleFunc("/api/webhooks/dead-letters/{id}/replay", Authenticate(ReplayDeadLetterHandler))
+	mux.HandleFunc("/api/company", Authenticate(CompanyHandler))
+	mux.HandleFunc("/api/alert-rules", Authenticate(AlertRuleHandler))
+	mux.HandleFunc("/api/maintenance-windows", Authenticate(MaintenanceWindowHandler))
+	mux.HandleFunc("/api/admin/weather-warnings/refresh", RequireAdmin(adminRefreshHandler))
 
 	// Configure CORS with rs/cors
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{frontendURL}, // Your frontend URL from .env
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key"},
 		AllowCredentials: true,
 	})
 
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"gorm.io/gorm"
+)
+
+// Recurrence values for maintenance windows.
+const (
+	recurrenceNone   = ""
+	recurrenceDaily  = "daily"
+	recurrenceWeekly = "weekly"
+)
+
+// maintenanceCheckInterval is how often window starts and ends are recorded.
+const maintenanceCheckInterval = time.Minute
+
+// recurrenceDays returns the number of days between occurrences, or 0 for a
+// one-off window.
+func recurrenceDays(recurrence string) int {
+	switch recurrence {
+	case recurrenceDaily:
+		return 1
+	case recurrenceWeekly:
+		return 7
+	default:
+		return 0
+	}
+}
+
+// maintenanceActive reports whether any occurrence of the window covers t.
+// Occurrences are stepped in calendar days of warningTimeZone so they keep
+// their Swedish time of day across daylight saving changes. The stored times
+// come back from the database without that zone.
+func maintenanceActive(window models.MaintenanceWindow, t time.Time) bool {
+	if t.Before(window.StartsAt) {
+		return false
+	}
+	length := window.EndsAt.Sub(window.StartsAt)
+	days := recurrenceDays(window.Recurrence)
+	if days == 0 {
+		return t.Before(window.EndsAt)
+	}
+
+	first := window.StartsAt.In(warningTimeZone)
+	n := int(t.Sub(first) / (time.Duration(days) * 24 * time.Hour))
+	for k := n - 1; k <= n+1; k++ {
+		if k < 0 {
+			continue
+		}
+		start := first.AddDate(0, 0, k*days)
+		if window.RecurUntil != nil && start.After(*window.RecurUntil) {
+			continue
+		}
+		if !t.Before(start) && t.Before(start.Add(length)) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeMaintenance returns the active window for each of the given sensors
+// that is in maintenance at now.
+func activeMaintenance(sensors []models.Sensor, now time.Time) (map[uint]*models.MaintenanceWindow, error) {
+	active := make(map[uint]*models.MaintenanceWindow)
+	if len(sensors) == 0 {
+		return active, nil
+	}
+	companyIDs := make([]uint, 0, len(sensors))
+	seen := make(map[uint]bool)
+	for _, sensor := range sensors {
+		if !seen[sensor.CompanyID] {
+			seen[sensor.CompanyID] = true
+			companyIDs = append(companyIDs, sensor.CompanyID)
+		}
+	}
+
+	var windows []models.MaintenanceWindow
+	err := db.Where("company_id IN ? AND starts_at <= ?", companyIDs, now).
+		Where("(ends_at > ? OR recurrence <> '')", now).
+		Find(&windows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range windows {
+		window := &windows[i]
+		if !maintenanceActive(*window, now) {
+			continue
+		}
+		for _, sensor := range sensors {
+			if sensor.CompanyID != window.CompanyID || active[sensor.ID] != nil {
+				continue
+			}
+			if (window.SensorID != nil && *window.SensorID == sensor.ID) ||
+				(window.SensorGroup != "" && window.SensorGroup == sensor.Group) {
+				active[sensor.ID] = window
+			}
+		}
+	}
+	return active, nil
+}
+
+// displayStatus is the status shown for a sensor, MAINTENANCE while a window
+// is active and the stored status otherwise.
+func displayStatus(sensor models.Sensor) string {
+	maintenance, err := activeMaintenance([]models.Sensor{sensor}, time.Now())
+	if err != nil {
+		log.Printf("Error checking maintenance windows for sensor %d: %v", sensor.ID, err)
+		return sensor.Status
+	}
+	if maintenance[sensor.ID] != nil {
+		return statusMaintenance
+	}
+	return sensor.Status
+}
+
+// applyMaintenanceStatus replaces the status of sensors in an active window
+// with MAINTENANCE, keeping the stored one in StoredStatus.
+func applyMaintenanceStatus(sensors []models.Sensor, now time.Time) error {
+	maintenance, err := activeMaintenance(sensors, now)
+	if err != nil {
+		return err
+	}
+	for i := range sensors {
+		if maintenance[sensors[i].ID] != nil {
+			sensors[i].StoredStatus = sensors[i].Status
+			sensors[i].Status = statusMaintenance
+		}
+	}
+	return nil
+}
+
+// recordMaintenanceChange flips a sensor's InMaintenance flag and records the
+// start or end of maintenance in its status history. The stored status is
+// left as is. When maintenance ends, webhooks are told about a status change
+// that was held back during the window.
+func recordMaintenanceChange(sensor models.Sensor, window *models.MaintenanceWindow, now time.Time) error {
+	change := models.SensorStatusChange{SensorID: sensor.ID, ChangedAt: now}
+	if window != nil {
+		change.From, change.To = sensor.Status, statusMaintenance
+		change.Reason = reasonMaintenanceStart
+		change.Detail = fmt.Sprintf("window %d %s", window.ID, window.Name)
+	} else {
+		change.From, change.To = statusMaintenance, sensor.Status
+		change.Reason = reasonMaintenanceEnd
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Sensor{}).
+			Where("id = ? AND in_maintenance = ?", sensor.ID, window == nil).
+			Update("in_maintenance", window != nil)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if err := tx.Create(&change).Error; err != nil {
+			return err
+		}
+		if window != nil {
+			return nil
+		}
+
+		// The status the sensor had when the window started
+		var start models.SensorStatusChange
+		err := tx.Where("sensor_id = ? AND reason = ?", sensor.ID, reasonMaintenanceStart).
+			Order("changed_at DESC, id DESC").
+			First(&start).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		notifications, err := maintenanceEndNotifications(tx, sensor, start.From, start.ChangedAt, now)
+		if err != nil || len(notifications) == 0 {
+			return err
+		}
+		webhooks, err := companyWebhooks(tx, sensor.CompanyID)
+		if err != nil {
+			return fmt.Errorf("fetching webhooks for company %d: %w", sensor.CompanyID, err)
+		}
+		for _, n := range notifications {
+			if err := enqueueDeliveries(tx, webhooks, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// maintenanceEndNotifications describes how a sensor's status changed from
+// before, its status when a maintenance window started at startedAt, using
+// the same notification kinds as a change outside maintenance.
+func maintenanceEndNotifications(tx *gorm.DB, sensor models.Sensor, before string, startedAt, now time.Time) ([]notification, error) {
+	switch {
+	case sensor.Status == before:
+		return nil, nil
+	case sensor.Status == statusOffline:
+		return []notification{newNotification(sensor, sensorPayload(sensor, notificationOffline))}, nil
+	case before == statusOffline:
+		return []notification{newNotification(sensor, sensorPayload(sensor, notificationOnline))}, nil
+	case parseSeverity(sensor.Status) < parseSeverity(before):
+		eventCode, err := raisingEventCode(tx, sensor.ID, before, startedAt)
+		if err != nil {
+			return nil, err
+		}
+		return []notification{resolvedNotification(sensor, before, eventCode)}, nil
+	}
+
+	// Raised during the window, report the warning that sets the status now
+	_, cause, err := currentWarning(tx, sensor, now)
+	if err != nil || cause == nil {
+		return nil, err
+	}
+	return []notification{warningNotification(sensor, *cause, nil)}, nil
+}
+
+// checkMaintenanceWindows records every sensor entering or leaving maintenance.
+func checkMaintenanceWindows(now time.Time) {
+	var sensors []models.Sensor
+	if err := db.Find(&sensors).Error; err != nil {
+		log.Printf("Error fetching sensors: %v", err)
+		return
+	}
+	maintenance, err := activeMaintenance(sensors, now)
+	if err != nil {
+		log.Printf("Error fetching maintenance windows: %v", err)
+		return
+	}
+	for _, sensor := range sensors {
+		window := maintenance[sensor.ID]
+		if (window != nil) == sensor.InMaintenance {
+			continue
+		}
+		if err := recordMaintenanceChange(sensor, window, now); err != nil {
+			log.Printf("Error recording maintenance for sensor %d: %v", sensor.ID, err)
+		}
+	}
+}
+
+// periodicMaintenanceCheck runs checkMaintenanceWindows until the process exits.
+func periodicMaintenanceCheck() {
+	ticker := time.NewTicker(maintenanceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			checkMaintenanceWindows(time.Now())
+		}
+	}
+}
+
+// MaintenanceWindowRequest creates or replaces a maintenance window. Exactly
+// one of SensorID and SensorGroup must be set.
+type MaintenanceWindowRequest struct {
+	SensorID    *uint      `json:"sensor_id"`
+	SensorGroup string     `json:"sensor_group"`
+	Name        string     `json:"name"`
+	StartsAt    time.Time  `json:"starts_at"`
+	EndsAt      time.Time  `json:"ends_at"`
+	Recurrence  string     `json:"recurrence"`
+	RecurUntil  *time.Time `json:"recur_until"`
+}
+
+func (req MaintenanceWindowRequest) validate(companyID uint) error {
+	if req.Name == "" {
+		return errors.New("Name is required")
+	}
+	if (req.SensorID == nil) == (req.SensorGroup == "") {
+		return errors.New("Exactly one of sensor_id and sensor_group is required")
+	}
+	if req.StartsAt.IsZero() || !req.EndsAt.After(req.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	switch req.Recurrence {
+	case recurrenceNone, recurrenceDaily, recurrenceWeekly:
+	default:
+		return errors.New("Recurrence must be empty, daily or weekly")
+	}
+	if days := recurrenceDays(req.Recurrence); days > 0 {
+		if req.EndsAt.Sub(req.StartsAt) >= time.Duration(days)*24*time.Hour {
+			return errors.New("A recurring window must be shorter than its period")
+		}
+		if req.RecurUntil != nil && req.RecurUntil.Before(req.StartsAt) {
+			return errors.New("recur_until must not be before starts_at")
+		}
+	} else if req.RecurUntil != nil {
+		return errors.New("recur_until requires a recurrence")
+	}
+	if req.SensorID != nil {
+		var count int64
+		db.Model(&models.Sensor{}).Where("id = ? AND company_id = ?", *req.SensorID, companyID).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("Sensor %d not found", *req.SensorID)
+		}
+	}
+	return nil
+}
+
+func (req MaintenanceWindowRequest) apply(window *models.MaintenanceWindow) {
+	window.SensorID = req.SensorID
+	window.SensorGroup = req.SensorGroup
+	window.Name = req.Name
+	window.StartsAt = req.StartsAt
+	window.EndsAt = req.EndsAt
+	window.Recurrence = req.Recurrence
+	window.RecurUntil = req.RecurUntil
+}
+
+// MaintenanceWindowHandler manages the maintenance windows of the user's
+// company, addressed by ?id= for updates and deletes.
+func MaintenanceWindowHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		windows := []models.MaintenanceWindow{}
+		if err := db.Where("company_id = ?", user.CompanyID).Order("starts_at").Find(&windows).Error; err != nil {
+			http.Error(w, "Error fetching maintenance windows", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(windows)
+	case http.MethodPost, http.MethodPut:
+		handleSaveMaintenanceWindow(w, r, user.CompanyID)
+	case http.MethodDelete:
+		windowID := r.URL.Query().Get("id")
+		if windowID == "" {
+			http.Error(w, "Missing maintenance window ID", http.StatusBadRequest)
+			return
+		}
+		if err := db.Where("id = ? AND company_id = ?", windowID, user.CompanyID).Delete(&models.MaintenanceWindow{}).Error; err != nil {
+			http.Error(w, "Error deleting maintenance window", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleSaveMaintenanceWindow(w http.ResponseWriter, r *http.Request, companyID uint) {
+	var req MaintenanceWindowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(companyID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	window := models.MaintenanceWindow{CompanyID: companyID}
+	status := http.StatusCreated
+	if r.Method == http.MethodPut {
+		windowID := r.URL.Query().Get("id")
+		if windowID == "" {
+			http.Error(w, "Missing maintenance window ID", http.StatusBadRequest)
+			return
+		}
+		if err := db.Where("id = ? AND company_id = ?", windowID, companyID).First(&window).Error; err != nil {
+			http.Error(w, "Maintenance window not found", http.StatusNotFound)
+			return
+		}
+		status = http.StatusOK
+	}
+
+	req.apply(&window)
+	if err := db.Save(&window).Error; err != nil {
+		http.Error(w, "Error saving maintenance window", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(window)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+func TestMaintenanceActive(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local is a time in Stockholm, the clocks moved forward on 30 March 2025
+	// and back on 26 October 2025
+	local := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2025, month, day, hour, min, 0, 0, stockholm)
+	}
+	ptr := func(t time.Time) *time.Time { return &t }
+	// window is stored in UTC, the way it is read back from the database
+	window := func(recurrence string, start, end time.Time, until *time.Time) models.MaintenanceWindow {
+		if until != nil {
+			until = ptr(until.UTC())
+		}
+		return models.MaintenanceWindow{StartsAt: start.UTC(), EndsAt: end.UTC(), Recurrence: recurrence, RecurUntil: until}
+	}
+
+	spring := window(recurrenceDaily, local(time.March, 27, 8, 0), local(time.March, 27, 9, 0), nil)
+	autumn := window(recurrenceDaily, local(time.October, 24, 8, 0), local(time.October, 24, 9, 0), nil)
+	weekly := window(recurrenceWeekly, local(time.March, 24, 22, 0), local(time.March, 24, 23, 30), nil)
+	until := window(recurrenceDaily, local(time.March, 27, 8, 0), local(time.March, 27, 9, 0), ptr(local(time.April, 2, 8, 30)))
+	once := window(recurrenceNone, local(time.March, 29, 22, 0), local(time.March, 30, 6, 0), nil)
+
+	tests := []struct {
+		name   string
+		window models.MaintenanceWindow
+		at     time.Time
+		want   bool
+	}{
+		{"before the first occurrence", spring, local(time.March, 26, 8, 30), false},
+		{"first occurrence", spring, local(time.March, 27, 8, 30), true},
+		{"start is inclusive", spring, local(time.March, 27, 8, 0), true},
+		{"end is exclusive", spring, local(time.March, 27, 9, 0), false},
+		{"between occurrences", spring, local(time.March, 28, 12, 0), false},
+		{"on the day clocks go forward", spring, local(time.March, 30, 8, 30), true},
+		{"an hour early on the day clocks go forward", spring, local(time.March, 30, 7, 30), false},
+		{"keeps local time after clocks go forward", spring, local(time.March, 31, 8, 15), true},
+		{"not at the old UTC time after clocks go forward", spring, local(time.March, 31, 9, 15), false},
+		{"weeks later", spring, local(time.May, 15, 8, 59), true},
+		{"keeps local time after clocks go back", autumn, local(time.October, 27, 8, 15), true},
+		{"not at the old UTC time after clocks go back", autumn, local(time.October, 27, 7, 15), false},
+		{"weekly on its weekday", weekly, local(time.March, 31, 22, 15), true},
+		{"weekly on another weekday", weekly, local(time.March, 25, 22, 15), false},
+		{"weekly end is exclusive", weekly, local(time.April, 7, 23, 30), false},
+		{"weekly just before its end", weekly, local(time.April, 7, 23, 29), true},
+		{"before recur_until", until, local(time.April, 1, 8, 30), true},
+		{"occurrence running at recur_until finishes", until, local(time.April, 2, 8, 45), true},
+		{"after recur_until", until, local(time.April, 3, 8, 30), false},
+		{"one-off across the change", once, local(time.March, 30, 5, 30), true},
+		{"one-off has ended", once, local(time.March, 30, 6, 0), false},
+		{"one-off does not recur", once, local(time.March, 30, 22, 30), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maintenanceActive(tt.window, tt.at); got != tt.want {
+				t.Errorf("maintenanceActive at %v = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowRequestValidate(t *testing.T) {
+	start := time.Date(2025, 3, 27, 8, 0, 0, 0, time.UTC)
+	ptr := func(t time.Time) *time.Time { return &t }
+	tests := []struct {
+		name    string
+		req     MaintenanceWindowRequest
+		wantErr string
+	}{
+		{
+			name: "one-off",
+			req:  MaintenanceWindowRequest{Name: "Service", SensorGroup: "roof", StartsAt: start, EndsAt: start.Add(time.Hour)},
+		},
+		{
+			name: "recurring until a later date",
+			req: MaintenanceWindowRequest{Name: "Service", SensorGroup: "roof", StartsAt: start, EndsAt: start.Add(time.Hour),
+				Recurrence: recurrenceDaily, RecurUntil: ptr(start.AddDate(0, 1, 0))},
+		},
+		{
+			name: "recurring until its first start",
+			req: MaintenanceWindowRequest{Name: "Service", SensorGroup: "roof", StartsAt: start, EndsAt: start.Add(time.Hour),
+				Recurrence: recurrenceWeekly, RecurUntil: ptr(start)},
+		},
+		{
+			name: "recur_until before starts_at",
+			req: MaintenanceWindowRequest{Name: "Service", SensorGroup: "roof", StartsAt: start, EndsAt: start.Add(time.Hour),
+				Recurrence: recurrenceDaily, RecurUntil: ptr(start.Add(-time.Minute))},
+			wantErr: "recur_until must not be before starts_at",
+		},
+		{
+			name: "recur_until without a recurrence",
+			req: MaintenanceWindowRequest{Name: "Service", SensorGroup: "roof", StartsAt: start, EndsAt: start.Add(time.Hour),
+				RecurUntil: ptr(start.AddDate(0, 1, 0))},
+			wantErr: "recur_until requires a recurrence",
+		},
+		{
+			name: "as long as its period",
+			req: MaintenanceWindowRequest{Name: "Service", SensorGroup: "roof", StartsAt: start, EndsAt: start.AddDate(0, 0, 1),
+				Recurrence: recurrenceDaily},
+			wantErr: "A recurring window must be shorter than its period",
+		},
+		{
+			name:    "ends before it starts",
+			req:     MaintenanceWindowRequest{Name: "Service", SensorGroup: "roof", StartsAt: start, EndsAt: start},
+			wantErr: "ends_at must be after starts_at",
+		},
+		{
+			name:    "unknown recurrence",
+			req:     MaintenanceWindowRequest{Name: "Service", SensorGroup: "roof", StartsAt: start, EndsAt: start.Add(time.Hour), Recurrence: "monthly"},
+			wantErr: "Recurrence must be empty, daily or weekly",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.validate(1)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,6 +2,10 @@
 package models
 
 import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -12,6 +16,7 @@ type User struct {
 	Company      Company   `gorm:"foreignKey:CompanyID"`
 	CompanyID    uint
 	Webhooks     []Webhook `gorm:"foreignKey:UserID"`
+	IsAdmin      bool      `gorm:"not null;default:false"`
 }
 
 type Company struct {
@@ -19,6 +24,8 @@ type Company struct {
 	Name    string  `gorm:"unique;not null"`
 	Users   []User  `gorm:"foreignKey:CompanyID"`
 	Sensors []Sensor `gorm:"foreignKey:CompanyID"`
+	AlertRadiusKm float64 `gorm:"not null;default:0"` // Default proximity radius for the company's sensors
+	MinWarningLevel string `gorm:"not null;default:ORANGE"` // Lowest SMHI warning level that triggers notifications
 }
 
 type Sensor struct {
@@ -30,10 +37,259 @@ type Sensor struct {
     Status      string  `gorm:"not null" json:"status"` // Proper JSON tag
     CompanyID   uint    `gorm:"not null" json:"company_id"`
     Description string  `json:"description"`
+    AlertRadiusKm float64 `gorm:"not null;default:0" json:"alert_radius_km"` // Overrides the company radius when set
+    DeviceStatus string `json:"device_status"` // Last status reported by the device itself
+    LastSeenAt *time.Time `gorm:"index" json:"last_seen_at"` // Last telemetry or heartbeat
+    Group string `gorm:"index" json:"group"` // Optional group name, used to target maintenance windows
+    Tags JSON `gorm:"type:jsonb" json:"tags"` // Free-form labels webhooks can filter on
+    InMaintenance bool `gorm:"not null;default:false" json:"in_maintenance"` // Set while a maintenance window is active
+    StoredStatus string `gorm:"-" json:"stored_status,omitempty"` // Status hidden behind MAINTENANCE in listings
 }
 
 type Webhook struct {
 	gorm.Model
 	URL    string `gorm:"not null"`
 	UserID uint   `gorm:"not null"`
+	// Secret signs deliveries. It is only returned on creation and rotation.
+	Secret string `gorm:"not null;default:''" json:"-"`
+	// PreviousSecret keeps signing deliveries until PreviousSecretExpiresAt
+	// so receivers can switch to a rotated secret without missing any.
+	PreviousSecret          string     `json:"-"`
+	PreviousSecretExpiresAt *time.Time `json:"-"`
+
+	Format   string `gorm:"not null;default:generic" json:"format"` // generic, slack, teams or discord
+	Language string `gorm:"not null;default:en" json:"language"`    // en or sv, for the chat formats
+
+	// Filters, each left empty to receive everything
+	MinWarningLevel string `json:"min_warning_level"`
+	EventCodes      JSON   `gorm:"type:jsonb" json:"event_codes"` // SMHI event codes, e.g. WIND
+	SensorIDs       JSON   `gorm:"type:jsonb" json:"sensor_ids"`
+	SensorTags      JSON   `gorm:"type:jsonb" json:"sensor_tags"`
+	EventKinds      JSON   `gorm:"type:jsonb" json:"event_kinds"` // warning, offline or threshold
+}
+
+// WarningAreaGeometry mirrors a cached SMHI warning area in PostGIS mode so
+// sensor matching can run as a single spatial query.
+type WarningAreaGeometry struct {
+	WarningID int    `gorm:"primaryKey;autoIncrement:false"`
+	AreaID    int    `gorm:"primaryKey;autoIncrement:false"`
+	Geom      string `gorm:"type:geography(MultiPolygon,4326);not null"`
+}
+
+// WarningRecord is an SMHI warning as persisted for the history API.
+type WarningRecord struct {
+	gorm.Model
+	SMHIID     int                 `gorm:"uniqueIndex;not null" json:"smhi_id"`
+	EventCode  string              `gorm:"index;not null" json:"event_code"`
+	EventEn    string              `json:"event_en"`
+	EventSv    string              `json:"event_sv"`
+	AreaNameEn string              `json:"area_name_en"`
+	AreaNameSv string              `json:"area_name_sv"`
+	FirstSeen  time.Time           `gorm:"not null" json:"first_seen"`
+	LastSeen   time.Time           `gorm:"not null" json:"last_seen"`
+	Areas      []WarningAreaRecord `gorm:"foreignKey:WarningRecordID" json:"areas"`
+}
+
+// WarningAreaRecord holds the latest state of a warning area. Earlier states
+// are kept as WarningAreaRevisions.
+type WarningAreaRecord struct {
+	gorm.Model
+	WarningRecordID  uint                  `gorm:"uniqueIndex:idx_warning_area_smhi;not null" json:"warning_record_id"`
+	SMHIID           int                   `gorm:"uniqueIndex:idx_warning_area_smhi;not null" json:"smhi_id"`
+	Level            string                `gorm:"index;not null" json:"level"`
+	ApproximateStart *time.Time            `gorm:"index" json:"approximate_start"`
+	ApproximateEnd   *time.Time            `gorm:"index" json:"approximate_end"`
+	Published        string                `json:"published"`
+	Geometry         JSON                  `gorm:"type:jsonb" json:"geometry"`
+	EndedAt          *time.Time            `json:"ended_at"`
+	AffectedAreas    []AffectedAreaRecord  `gorm:"foreignKey:WarningAreaRecordID" json:"affected_areas"`
+	Revisions        []WarningAreaRevision `gorm:"foreignKey:WarningAreaRecordID" json:"revisions,omitempty"`
+}
+
+type AffectedAreaRecord struct {
+	ID                  uint   `gorm:"primaryKey" json:"id"`
+	WarningAreaRecordID uint   `gorm:"index;not null" json:"-"`
+	SMHIID              int    `json:"smhi_id"`
+	Sv                  string `gorm:"index" json:"sv"`
+	En                  string `json:"en"`
+}
+
+// WarningAreaRevision is one observed state of a warning area together with
+// the lifecycle transition that produced it.
+type WarningAreaRevision struct {
+	gorm.Model
+	WarningAreaRecordID uint       `gorm:"index;not null" json:"warning_area_record_id"`
+	Kind                string     `gorm:"not null" json:"kind"`
+	Level               string     `json:"level"`
+	ApproximateStart    *time.Time `json:"approximate_start"`
+	ApproximateEnd      *time.Time `json:"approximate_end"`
+	Published           string     `json:"published"`
+	Geometry            JSON       `gorm:"type:jsonb" json:"geometry"`
+}
+
+// JSON is a raw JSON document stored in a jsonb column and encoded as-is in
+// API responses.
+type JSON []byte
+
+func (j JSON) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+func (j *JSON) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		*j = append((*j)[:0], v...)
+	case string:
+		*j = JSON(v)
+	default:
+		return fmt.Errorf("cannot scan %T into JSON", value)
+	}
+	return nil
+}
+
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+func (j *JSON) UnmarshalJSON(data []byte) error {
+	*j = append((*j)[:0], data...)
+	return nil
+}
+
+// WarningSnapshot keeps the last successfully fetched warning.json so the
+// backend can serve it when SMHI is unreachable at startup.
+type WarningSnapshot struct {
+	ID        uint      `gorm:"primaryKey"`
+	Data      JSON      `gorm:"type:jsonb;not null"`
+	FetchedAt time.Time `gorm:"not null"`
+}
+
+// Reading is a single measurement reported by a sensor. Rows are keyed by
+// sensor, metric and timestamp so a resent sample overwrites the original.
+type Reading struct {
+	SensorID   uint      `gorm:"primaryKey;autoIncrement:false;index:idx_readings_sensor_time,priority:1" json:"sensor_id"`
+	Metric     string    `gorm:"primaryKey;size:64" json:"metric"`
+	RecordedAt time.Time `gorm:"primaryKey;index:idx_readings_sensor_time,priority:2" json:"recorded_at"`
+	Value      float64   `gorm:"not null" json:"value"`
+}
+
+// SensorKey is a device credential bound to one sensor. Only a SHA-256 hash
+// of the secret is stored, the plaintext is shown once at creation.
+type SensorKey struct {
+	gorm.Model
+	SensorID   uint       `gorm:"index;not null" json:"sensor_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `gorm:"uniqueIndex;size:16;not null" json:"prefix"`
+	Hash       string     `gorm:"not null" json:"-"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"` // Set when the key is rotated out
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// AlertRule raises an alert when a sensor metric crosses a threshold for at
+// least Duration. An active alert clears once the value is back past the
+// threshold by more than Hysteresis.
+type AlertRule struct {
+	gorm.Model
+	CompanyID       uint    `gorm:"index;not null" json:"company_id"`
+	SensorID        *uint   `gorm:"index" json:"sensor_id"` // Applies to every company sensor when nil
+	Name            string  `gorm:"not null" json:"name"`
+	Metric          string  `gorm:"not null" json:"metric"`
+	Comparator      string  `gorm:"not null" json:"comparator"` // gt, gte, lt or lte
+	Threshold       float64 `gorm:"not null" json:"threshold"`
+	DurationSeconds int     `gorm:"not null;default:0" json:"duration_seconds"`
+	Hysteresis      float64 `gorm:"not null;default:0" json:"hysteresis"`
+	Enabled         bool    `gorm:"not null" json:"enabled"` // No column default, GORM would skip a false value on create
+}
+
+// AlertRuleState tracks the evaluation of a rule for one sensor.
+type AlertRuleState struct {
+	AlertRuleID     uint       `gorm:"primaryKey;autoIncrement:false"`
+	SensorID        uint       `gorm:"primaryKey;autoIncrement:false"`
+	BreachStartedAt *time.Time // When the condition started holding, nil if it does not
+	Firing          bool       `gorm:"not null;default:false"`
+	TriggeredAt     *time.Time
+	LastValue       float64
+	LastEvaluatedAt time.Time
+}
+
+// ReadingRollup aggregates the readings of one sensor metric over a time
+// bucket. Resolution is "1m", "1h" or "1d".
+type ReadingRollup struct {
+	SensorID    uint      `gorm:"primaryKey;autoIncrement:false" json:"sensor_id"`
+	Metric      string    `gorm:"primaryKey;size:64" json:"metric"`
+	Resolution  string    `gorm:"primaryKey;size:8" json:"resolution"`
+	BucketStart time.Time `gorm:"primaryKey" json:"bucket_start"`
+	Min         float64   `gorm:"not null" json:"min"`
+	Max         float64   `gorm:"not null" json:"max"`
+	Sum         float64   `gorm:"not null" json:"sum"`
+	Count       int64     `gorm:"not null" json:"count"`
+}
+
+// SensorStatusChange records one transition of Sensor.Status.
+type SensorStatusChange struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	SensorID  uint      `gorm:"index:idx_status_changes_sensor_time,priority:1;not null" json:"sensor_id"`
+	From      string    `json:"from"`
+	To        string    `gorm:"not null" json:"to"`
+	Reason    string    `gorm:"not null" json:"reason"`
+	Detail    string    `json:"detail,omitempty"`
+	ChangedAt time.Time `gorm:"index:idx_status_changes_sensor_time,priority:2;not null" json:"changed_at"`
+}
+
+// MaintenanceWindow suppresses notifications for one sensor or every sensor
+// in a group. Recurring windows repeat daily or weekly from StartsAt, each
+// occurrence lasting EndsAt - StartsAt, until RecurUntil when set.
+type MaintenanceWindow struct {
+	gorm.Model
+	CompanyID   uint       `gorm:"index;not null" json:"company_id"`
+	SensorID    *uint      `gorm:"index" json:"sensor_id"`
+	SensorGroup string     `json:"sensor_group"`
+	Name        string     `gorm:"not null" json:"name"`
+	StartsAt    time.Time  `gorm:"not null" json:"starts_at"`
+	EndsAt      time.Time  `gorm:"not null" json:"ends_at"`
+	Recurrence  string     `json:"recurrence"` // Empty, daily or weekly
+	RecurUntil  *time.Time `json:"recur_until"`
+}
+
+// WebhookDelivery is one notification queued for a webhook. Rows are
+// written in the same transaction as the change they report and delivered
+// by the outbox workers.
+type WebhookDelivery struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	WebhookID     uint       `gorm:"index;not null" json:"webhook_id"`
+	DeliveryID    string     `gorm:"uniqueIndex;not null" json:"delivery_id"` // Sent as X-Webhook-ID, stable across retries
+	Kind          string     `gorm:"not null" json:"kind"`
+	Payload       JSON       `gorm:"type:jsonb;not null" json:"payload"`
+	State         string     `gorm:"index:idx_webhook_deliveries_due,priority:1;not null" json:"state"` // pending, delivered or dead
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"index:idx_webhook_deliveries_due,priority:2;not null" json:"next_attempt_at"`
+	LastStatus    int        `json:"last_status,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	RedeliveryOf  *uint      `json:"redelivery_of,omitempty"` // Delivery this one was manually redelivered from
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+
+	AttemptLog []WebhookAttempt `gorm:"foreignKey:WebhookDeliveryID" json:"attempt_log,omitempty"`
+}
+
+// WebhookAttempt records one attempt at a delivery. The body sent is always
+// the delivery's payload, so it is not copied here.
+type WebhookAttempt struct {
+	ID                uint      `gorm:"primaryKey" json:"id"`
+	WebhookDeliveryID uint      `gorm:"index;not null" json:"webhook_delivery_id"`
+	Attempt           int       `gorm:"not null" json:"attempt"`
+	ResponseStatus    int       `json:"response_status,omitempty"`
+	LatencyMs         int64     `json:"latency_ms"`
+	Error             string    `json:"error,omitempty"`
+	AttemptedAt       time.Time `gorm:"not null" json:"attempted_at"`
 }
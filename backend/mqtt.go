@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// defaultMQTTTopicPattern is used when MQTT_TOPIC_PATTERN is not set.
+const defaultMQTTTopicPattern = "tenants/{company}/sensors/{id}/{kind}"
+
+// Message kinds, taken from the {kind} topic segment.
+const (
+	mqttKindTelemetry = "telemetry"
+	mqttKindStatus    = "status"
+	mqttKindHeartbeat = "heartbeat"
+)
+
+// mqttConfig is read from the MQTT_* environment variables. The bridge is
+// disabled when BrokerURL is empty.
+type mqttConfig struct {
+	BrokerURL    string
+	ClientID     string
+	Username     string
+	Password     string
+	TopicPattern string
+	QoS          byte
+}
+
+// topicPattern is an MQTT topic with {company}, {id}, {key} and {kind}
+// placeholders, each standing for exactly one topic level. {company} is a
+// company ID and {key} the public prefix of a device key.
+//
+// Every topic is scoped to a company or bound to a device key, so a sensor
+// ID alone never addresses a sensor. The broker's ACLs should in turn only
+// let each device publish below its own company or key.
+type topicPattern struct {
+	levels []string
+}
+
+func parseTopicPattern(pattern string) (topicPattern, error) {
+	levels := strings.Split(pattern, "/")
+	found := make(map[string]bool)
+	for _, level := range levels {
+		if level == "+" || level == "#" {
+			return topicPattern{}, fmt.Errorf("topic pattern must use placeholders, not %q", level)
+		}
+		if strings.HasPrefix(level, "{") && strings.HasSuffix(level, "}") {
+			name := level[1 : len(level)-1]
+			switch name {
+			case "company", "id", "key", "kind":
+				found[name] = true
+			default:
+				return topicPattern{}, fmt.Errorf("unknown placeholder %q", level)
+			}
+		}
+	}
+	if !found["kind"] {
+		return topicPattern{}, errors.New("topic pattern needs a {kind} placeholder")
+	}
+	if !found["key"] && !(found["company"] && found["id"]) {
+		return topicPattern{}, errors.New("topic pattern needs a {key} placeholder, or {company} and {id}")
+	}
+	return topicPattern{levels: levels}, nil
+}
+
+// subscription returns the pattern with every placeholder replaced by "+".
+func (p topicPattern) subscription() string {
+	levels := make([]string, len(p.levels))
+	for i, level := range p.levels {
+		if strings.HasPrefix(level, "{") {
+			level = "+"
+		}
+		levels[i] = level
+	}
+	return strings.Join(levels, "/")
+}
+
+// match extracts the placeholder values from a concrete topic.
+func (p topicPattern) match(topic string) (map[string]string, bool) {
+	levels := strings.Split(topic, "/")
+	if len(levels) != len(p.levels) {
+		return nil, false
+	}
+	vars := make(map[string]string)
+	for i, level := range p.levels {
+		if strings.HasPrefix(level, "{") {
+			vars[level[1:len(level)-1]] = levels[i]
+		} else if level != levels[i] {
+			return nil, false
+		}
+	}
+	return vars, true
+}
+
+// mqttStore is the database side of the bridge, an interface so messages can
+// be handled in tests without a database.
+type mqttStore interface {
+	findSensor(id uint) (models.Sensor, error)
+	findSensorKey(prefix string) (models.SensorKey, error)
+	ingestReadings(sensor models.Sensor, readings []ReadingInput) error
+	setDeviceStatus(sensor models.Sensor, status string) error
+	touchSensor(sensorID uint, now time.Time)
+}
+
+// dbMQTTStore is the mqttStore used in production.
+type dbMQTTStore struct{}
+
+func (dbMQTTStore) findSensor(id uint) (models.Sensor, error) {
+	var sensor models.Sensor
+	err := db.First(&sensor, id).Error
+	return sensor, err
+}
+
+func (dbMQTTStore) findSensorKey(prefix string) (models.SensorKey, error) {
+	var key models.SensorKey
+	err := db.Where("prefix = ?", prefix).First(&key).Error
+	return key, err
+}
+
+func (dbMQTTStore) ingestReadings(sensor models.Sensor, readings []ReadingInput) error {
+	_, err := ingestReadings(sensor, readings)
+	return err
+}
+
+func (dbMQTTStore) setDeviceStatus(sensor models.Sensor, status string) error {
+	return db.Model(&sensor).UpdateColumn("device_status", status).Error
+}
+
+func (dbMQTTStore) touchSensor(sensorID uint, now time.Time) {
+	touchSensor(sensorID, now)
+}
+
+// mqttBridge maps messages from the broker onto sensors and their readings.
+type mqttBridge struct {
+	client  mqtt.Client
+	pattern topicPattern
+	qos     byte
+	store   mqttStore
+}
+
+// newMQTTBridge prepares a bridge and its client without connecting. The
+// subscription is renewed on every reconnect.
+func newMQTTBridge(cfg mqttConfig, store mqttStore) (*mqttBridge, error) {
+	pattern, err := parseTopicPattern(cfg.TopicPattern)
+	if err != nil {
+		return nil, err
+	}
+	bridge := &mqttBridge{pattern: pattern, qos: cfg.QoS, store: store}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.BrokerURL).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second).
+		SetOrderMatters(false)
+	opts.OnConnect = func(c mqtt.Client) {
+		topic := pattern.subscription()
+		token := c.Subscribe(topic, bridge.qos, func(_ mqtt.Client, msg mqtt.Message) {
+			if err := bridge.handleMessage(msg.Topic(), msg.Payload(), msg.Retained()); err != nil {
+				log.Printf("Dropping MQTT message on %s: %v", msg.Topic(), err)
+			}
+		})
+		if token.Wait() && token.Error() != nil {
+			log.Printf("Error subscribing to MQTT topic %s: %v", topic, token.Error())
+			return
+		}
+		log.Printf("Subscribed to MQTT topic %s", topic)
+	}
+	opts.OnConnectionLost = func(_ mqtt.Client, err error) {
+		log.Printf("MQTT connection lost: %v", err)
+	}
+
+	bridge.client = mqtt.NewClient(opts)
+	return bridge, nil
+}
+
+// startMQTTBridge connects to the broker and subscribes to the topic pattern.
+func startMQTTBridge(cfg mqttConfig) (*mqttBridge, error) {
+	bridge, err := newMQTTBridge(cfg, dbMQTTStore{})
+	if err != nil {
+		return nil, err
+	}
+	// With ConnectRetry the token only completes once connected, so do not wait on it.
+	bridge.client.Connect()
+	return bridge, nil
+}
+
+// handleMessage resolves the sensor named by the topic and applies the
+// payload. It does not depend on the client so it can be driven directly.
+//
+// Retained messages are replayed by the broker on every subscribe, so they
+// say nothing about whether the device is alive. A retained status still
+// updates the device status, retained telemetry and heartbeats are dropped.
+func (b *mqttBridge) handleMessage(topic string, payload []byte, retained bool) error {
+	vars, ok := b.pattern.match(topic)
+	if !ok {
+		return errors.New("topic does not match pattern")
+	}
+	sensor, err := b.resolveTopicSensor(vars, time.Now())
+	if err != nil {
+		return err
+	}
+
+	switch vars["kind"] {
+	case mqttKindTelemetry:
+		if retained {
+			return errors.New("retained telemetry is ignored")
+		}
+		readings, err := decodeTelemetry(payload)
+		if err != nil {
+			return fmt.Errorf("malformed telemetry: %w", err)
+		}
+		return b.store.ingestReadings(sensor, readings)
+	case mqttKindStatus:
+		status, err := decodeDeviceStatus(payload)
+		if err != nil {
+			return fmt.Errorf("malformed status: %w", err)
+		}
+		if err := b.store.setDeviceStatus(sensor, status); err != nil {
+			return err
+		}
+		if !retained {
+			b.store.touchSensor(sensor.ID, time.Now())
+		}
+		return nil
+	case mqttKindHeartbeat:
+		if retained {
+			return errors.New("retained heartbeat is ignored")
+		}
+		b.store.touchSensor(sensor.ID, time.Now())
+		return nil
+	default:
+		return fmt.Errorf("unknown message kind %q", vars["kind"])
+	}
+}
+
+// resolveTopicSensor finds the sensor for a topic. A {key} level must name
+// an active device key and selects its sensor, a {company} level must be
+// the ID of the company owning the sensor, and an {id} level must agree
+// with both.
+func (b *mqttBridge) resolveTopicSensor(vars map[string]string, now time.Time) (models.Sensor, error) {
+	var sensorID uint64
+	if id, ok := vars["id"]; ok {
+		var err error
+		if sensorID, err = strconv.ParseUint(id, 10, 64); err != nil {
+			return models.Sensor{}, fmt.Errorf("invalid sensor ID %q", id)
+		}
+	}
+
+	if prefix, ok := vars["key"]; ok {
+		key, err := b.store.findSensorKey(prefix)
+		if err != nil {
+			return models.Sensor{}, fmt.Errorf("unknown device key %q", prefix)
+		}
+		if key.RevokedAt != nil || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+			return models.Sensor{}, fmt.Errorf("device key %q is no longer valid", prefix)
+		}
+		if sensorID != 0 && uint64(key.SensorID) != sensorID {
+			return models.Sensor{}, fmt.Errorf("device key %q does not belong to sensor %d", prefix, sensorID)
+		}
+		sensorID = uint64(key.SensorID)
+	}
+
+	sensor, err := b.store.findSensor(uint(sensorID))
+	if err != nil {
+		return sensor, fmt.Errorf("unknown sensor %d", sensorID)
+	}
+	if company, ok := vars["company"]; ok && company != strconv.FormatUint(uint64(sensor.CompanyID), 10) {
+		return sensor, fmt.Errorf("sensor %d does not belong to company %q", sensorID, company)
+	}
+	return sensor, nil
+}
+
+// decodeTelemetry accepts either the HTTP ingestion body,
+// {"readings": [...]}, or a flat object of metric values with an optional
+// "recorded_at" timestamp, e.g. {"temperature": 3.5, "humidity": 81}.
+func decodeTelemetry(payload []byte) ([]ReadingInput, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+
+	if _, ok := fields["readings"]; ok {
+		var req ReadingsRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		return req.Readings, nil
+	}
+
+	var recordedAt *time.Time
+	if raw, ok := fields["recorded_at"]; ok {
+		var t time.Time
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return nil, fmt.Errorf("recorded_at: %w", err)
+		}
+		recordedAt = &t
+		delete(fields, "recorded_at")
+	}
+
+	readings := make([]ReadingInput, 0, len(fields))
+	for metric, raw := range fields {
+		var value float64
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("%s: expected a number", metric)
+		}
+		readings = append(readings, ReadingInput{Metric: metric, Value: value, RecordedAt: recordedAt})
+	}
+	return readings, nil
+}
+
+// decodeDeviceStatus accepts {"status": "..."}, a JSON string or plain text.
+func decodeDeviceStatus(payload []byte) (string, error) {
+	var body struct {
+		Status string `json:"status"`
+	}
+	var bare string
+	status := strings.TrimSpace(string(payload))
+	if err := json.Unmarshal(payload, &bare); err == nil {
+		status = bare
+	} else if err := json.Unmarshal(payload, &body); err == nil {
+		status = body.Status
+	}
+	if status == "" || len(status) > 64 {
+		return "", errors.New("status must be 1 to 64 characters")
+	}
+	return status, nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeMQTTStore records what the bridge writes instead of using a database.
+type fakeMQTTStore struct {
+	mu       sync.Mutex
+	sensors  map[uint]models.Sensor
+	keys     map[string]models.SensorKey
+	readings map[uint][]ReadingInput
+	statuses map[uint]string
+	touched  map[uint]int
+	changed  chan struct{}
+}
+
+func newFakeMQTTStore() *fakeMQTTStore {
+	sensor := func(id, companyID uint) models.Sensor {
+		s := models.Sensor{CompanyID: companyID}
+		s.ID = id
+		return s
+	}
+	key := func(sensorID uint) models.SensorKey {
+		return models.SensorKey{SensorID: sensorID}
+	}
+	revoked := key(1)
+	revoked.RevokedAt = new(time.Time)
+	expired := key(1)
+	expiredAt := time.Now().Add(-time.Minute)
+	expired.ExpiresAt = &expiredAt
+
+	return &fakeMQTTStore{
+		sensors: map[uint]models.Sensor{1: sensor(1, 10), 2: sensor(2, 20)},
+		keys: map[string]models.SensorKey{
+			"a1b2c3": key(1),
+			"d4e5f6": key(2),
+			"revokd": revoked,
+			"expird": expired,
+		},
+		readings: make(map[uint][]ReadingInput),
+		statuses: make(map[uint]string),
+		touched:  make(map[uint]int),
+		changed:  make(chan struct{}, 16),
+	}
+}
+
+func (s *fakeMQTTStore) findSensor(id uint) (models.Sensor, error) {
+	sensor, ok := s.sensors[id]
+	if !ok {
+		return sensor, errors.New("record not found")
+	}
+	return sensor, nil
+}
+
+func (s *fakeMQTTStore) findSensorKey(prefix string) (models.SensorKey, error) {
+	key, ok := s.keys[prefix]
+	if !ok {
+		return key, errors.New("record not found")
+	}
+	return key, nil
+}
+
+func (s *fakeMQTTStore) ingestReadings(sensor models.Sensor, readings []ReadingInput) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readings[sensor.ID] = append(s.readings[sensor.ID], readings...)
+	s.touched[sensor.ID]++
+	s.changed <- struct{}{}
+	return nil
+}
+
+func (s *fakeMQTTStore) setDeviceStatus(sensor models.Sensor, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[sensor.ID] = status
+	s.changed <- struct{}{}
+	return nil
+}
+
+func (s *fakeMQTTStore) touchSensor(sensorID uint, _ time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touched[sensorID]++
+}
+
+func newTestBridge(t *testing.T, pattern string) (*mqttBridge, *fakeMQTTStore) {
+	t.Helper()
+	store := newFakeMQTTStore()
+	bridge, err := newMQTTBridge(mqttConfig{BrokerURL: "tcp://127.0.0.1:1", TopicPattern: pattern}, store)
+	if err != nil {
+		t.Fatalf("newMQTTBridge: %v", err)
+	}
+	return bridge, store
+}
+
+func TestHandleMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		pattern      string
+		topic        string
+		payload      string
+		retained     bool
+		wantErr      bool
+		wantReadings int
+		wantStatus   string
+		wantTouched  int
+	}{
+		{name: "flat telemetry", topic: "tenants/10/sensors/1/telemetry",
+			payload: `{"temperature": 3.5, "humidity": 81}`, wantReadings: 2, wantTouched: 1},
+		{name: "readings envelope", topic: "tenants/10/sensors/1/telemetry",
+			payload: `{"readings": [{"metric": "water_level", "value": 2.4}]}`, wantReadings: 1, wantTouched: 1},
+		{name: "heartbeat", topic: "tenants/10/sensors/1/heartbeat", wantTouched: 1},
+		{name: "status", topic: "tenants/10/sensors/1/status", payload: `{"status": "battery low"}`,
+			wantStatus: "battery low", wantTouched: 1},
+		{name: "retained status is not a sign of life", topic: "tenants/10/sensors/1/status", payload: "rebooting",
+			retained: true, wantStatus: "rebooting"},
+		{name: "retained telemetry", topic: "tenants/10/sensors/1/telemetry", payload: `{"temperature": 3.5}`,
+			retained: true, wantErr: true},
+		{name: "retained heartbeat", topic: "tenants/10/sensors/1/heartbeat", retained: true, wantErr: true},
+		{name: "sensor of another company", topic: "tenants/10/sensors/2/telemetry",
+			payload: `{"temperature": 3.5}`, wantErr: true},
+		{name: "company name instead of ID", topic: "tenants/Acme/sensors/1/telemetry",
+			payload: `{"temperature": 3.5}`, wantErr: true},
+		{name: "unknown sensor", topic: "tenants/10/sensors/99/telemetry", payload: `{"temperature": 3.5}`, wantErr: true},
+		{name: "invalid sensor ID", topic: "tenants/10/sensors/abc/telemetry", payload: `{"temperature": 3.5}`, wantErr: true},
+		{name: "malformed JSON", topic: "tenants/10/sensors/1/telemetry", payload: `{"temperature": `, wantErr: true},
+		{name: "non-numeric metric", topic: "tenants/10/sensors/1/telemetry", payload: `{"temperature": "warm"}`, wantErr: true},
+		{name: "empty status", topic: "tenants/10/sensors/1/status", payload: `{"status": ""}`, wantErr: true},
+		{name: "unknown kind", topic: "tenants/10/sensors/1/config", payload: `{}`, wantErr: true},
+		{name: "topic outside the pattern", topic: "tenants/10/sensors/1", payload: `{}`, wantErr: true},
+
+		{name: "device key", pattern: "devices/{key}/{kind}", topic: "devices/a1b2c3/telemetry",
+			payload: `{"temperature": 3.5}`, wantReadings: 1, wantTouched: 1},
+		{name: "unknown device key", pattern: "devices/{key}/{kind}", topic: "devices/ffffff/telemetry",
+			payload: `{"temperature": 3.5}`, wantErr: true},
+		{name: "revoked device key", pattern: "devices/{key}/{kind}", topic: "devices/revokd/heartbeat", wantErr: true},
+		{name: "expired device key", pattern: "devices/{key}/{kind}", topic: "devices/expird/heartbeat", wantErr: true},
+		{name: "device key of another sensor", pattern: "devices/{key}/sensors/{id}/{kind}",
+			topic: "devices/d4e5f6/sensors/1/heartbeat", wantErr: true},
+		{name: "device key of another company", pattern: "tenants/{company}/devices/{key}/{kind}",
+			topic: "tenants/10/devices/d4e5f6/heartbeat", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern := tt.pattern
+			if pattern == "" {
+				pattern = defaultMQTTTopicPattern
+			}
+			bridge, store := newTestBridge(t, pattern)
+
+			err := bridge.handleMessage(tt.topic, []byte(tt.payload), tt.retained)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handleMessage error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var readings, touched int
+			for id := range store.sensors {
+				readings += len(store.readings[id])
+				touched += store.touched[id]
+			}
+			if readings != tt.wantReadings {
+				t.Errorf("stored %d readings, want %d", readings, tt.wantReadings)
+			}
+			if touched != tt.wantTouched {
+				t.Errorf("touched sensors %d times, want %d", touched, tt.wantTouched)
+			}
+			if got := store.statuses[1]; got != tt.wantStatus {
+				t.Errorf("device status = %q, want %q", got, tt.wantStatus)
+			}
+			if tt.wantErr && len(store.statuses[2]) > 0 {
+				t.Error("rejected message changed sensor 2")
+			}
+		})
+	}
+}
+
+func TestParseTopicPattern(t *testing.T) {
+	valid := []string{
+		defaultMQTTTopicPattern,
+		"devices/{key}/{kind}",
+		"tenants/{company}/devices/{key}/sensors/{id}/{kind}",
+	}
+	for _, pattern := range valid {
+		if _, err := parseTopicPattern(pattern); err != nil {
+			t.Errorf("parseTopicPattern(%q) = %v, want no error", pattern, err)
+		}
+	}
+
+	invalid := []string{
+		"sensors/{id}/{kind}",
+		"tenants/{company}/{kind}",
+		"tenants/{company}/sensors/{id}/telemetry",
+		"tenants/+/sensors/{id}/{kind}",
+		"tenants/{company}/sensors/{id}/#",
+		"tenants/{tenant}/sensors/{id}/{kind}",
+	}
+	for _, pattern := range invalid {
+		if _, err := parseTopicPattern(pattern); err == nil {
+			t.Errorf("parseTopicPattern(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestDecodeTelemetry(t *testing.T) {
+	readings, err := decodeTelemetry([]byte(`{"temperature": -21.5, "humidity": 81, "recorded_at": "2025-01-14T06:00:00Z"}`))
+	if err != nil {
+		t.Fatalf("decodeTelemetry: %v", err)
+	}
+	sort.Slice(readings, func(i, j int) bool { return readings[i].Metric < readings[j].Metric })
+	want := time.Date(2025, 1, 14, 6, 0, 0, 0, time.UTC)
+	if len(readings) != 2 || readings[0].Metric != "humidity" || readings[1].Value != -21.5 {
+		t.Fatalf("decodeTelemetry = %+v", readings)
+	}
+	for _, reading := range readings {
+		if reading.RecordedAt == nil || !reading.RecordedAt.Equal(want) {
+			t.Errorf("%s recorded at %v, want %v", reading.Metric, reading.RecordedAt, want)
+		}
+	}
+
+	readings, err = decodeTelemetry([]byte(`{"readings": [{"metric": "water_level", "value": 2.31}]}`))
+	if err != nil || len(readings) != 1 || readings[0].Metric != "water_level" || readings[0].Value != 2.31 {
+		t.Errorf("decodeTelemetry envelope = %+v, %v", readings, err)
+	}
+
+	malformed := []string{
+		``,
+		`{"temperature": `,
+		`[1, 2, 3]`,
+		`"3.5"`,
+		`{"temperature": "warm"}`,
+		`{"temperature": 3.5, "recorded_at": "yesterday"}`,
+		`{"readings": {"metric": "temperature"}}`,
+	}
+	for _, payload := range malformed {
+		if readings, err := decodeTelemetry([]byte(payload)); err == nil {
+			t.Errorf("decodeTelemetry(%q) = %+v, want an error", payload, readings)
+		}
+	}
+}
+
+func TestDecodeDeviceStatus(t *testing.T) {
+	tests := []struct {
+		payload, want string
+	}{
+		{`{"status": "ok"}`, "ok"},
+		{`"charging"`, "charging"},
+		{"  low battery\n", "low battery"},
+	}
+	for _, tt := range tests {
+		if got, err := decodeDeviceStatus([]byte(tt.payload)); err != nil || got != tt.want {
+			t.Errorf("decodeDeviceStatus(%q) = %q, %v, want %q", tt.payload, got, err, tt.want)
+		}
+	}
+	for _, payload := range []string{``, `{"status": ""}`, fmt.Sprintf("%065d", 0)} {
+		if _, err := decodeDeviceStatus([]byte(payload)); err == nil {
+			t.Errorf("decodeDeviceStatus(%q) succeeded, want an error", payload)
+		}
+	}
+}
+
+// TestMQTTBridgeBroker runs the bridge against a real broker, for example
+// a local Mosquitto started with `mosquitto -p 1883`:
+//
+//	MQTT_TEST_BROKER=tcp://localhost:1883 go test -run TestMQTTBridgeBroker
+func TestMQTTBridgeBroker(t *testing.T) {
+	brokerURL := os.Getenv("MQTT_TEST_BROKER")
+	if brokerURL == "" {
+		t.Skip("MQTT_TEST_BROKER not set")
+	}
+	// A unique prefix keeps runs from seeing each other's retained messages
+	prefix := fmt.Sprintf("weather-iot-test-%d", time.Now().UnixNano())
+	publisher := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(brokerURL).SetClientID(prefix + "-device"))
+	if token := publisher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("connecting publisher: %v", token.Error())
+	}
+	defer publisher.Disconnect(100)
+	publish := func(topic, payload string, retained bool) {
+		t.Helper()
+		if token := publisher.Publish(prefix+"/"+topic, 1, retained, payload); token.Wait() && token.Error() != nil {
+			t.Fatalf("publishing %s: %v", topic, token.Error())
+		}
+	}
+
+	// Published before the bridge subscribes, so it arrives as a retained message
+	publish("tenants/10/sensors/1/status", "rebooting", true)
+	defer publish("tenants/10/sensors/1/status", "", true)
+
+	store := newFakeMQTTStore()
+	bridge, err := newMQTTBridge(mqttConfig{
+		BrokerURL:    brokerURL,
+		ClientID:     prefix + "-bridge",
+		TopicPattern: prefix + "/" + defaultMQTTTopicPattern,
+		QoS:          1,
+	}, store)
+	if err != nil {
+		t.Fatalf("newMQTTBridge: %v", err)
+	}
+	bridge.client.Connect()
+	defer bridge.client.Disconnect(100)
+
+	wait := func(what string) {
+		t.Helper()
+		select {
+		case <-store.changed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+	wait("the retained status")
+
+	publish("tenants/20/sensors/1/telemetry", `{"temperature": 1}`, false)
+	publish("tenants/10/sensors/1/telemetry", `{"temperature": `, false)
+	publish("tenants/10/sensors/1/telemetry", `{"temperature": -4.5}`, false)
+	wait("the telemetry")
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.statuses[1] != "rebooting" {
+		t.Errorf("device status = %q, want rebooting", store.statuses[1])
+	}
+	if got := store.readings[1]; len(got) != 1 || got[0].Value != -4.5 {
+		t.Errorf("readings = %+v, want only the well-formed telemetry", got)
+	}
+	if store.touched[1] != 1 {
+		t.Errorf("sensor touched %d times, want once for the telemetry", store.touched[1])
+	}
+}
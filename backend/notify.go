@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"gorm.io/gorm"
+)
+
+// Notification kinds, sent as "kind" in every webhook payload.
+const (
+	notificationWarning = "warning"
+	notificationOffline = "offline"
+	notificationOnline  = "online"
+	// notificationResolved is sent when a sensor's warning status is lowered
+	// because the warnings that raised it have ended
+	notificationResolved = "resolved"
+	// notificationThreshold is sent when an alert rule triggers or resolves
+	notificationThreshold = "threshold"
+)
+
+// companyWebhooks returns the webhooks of every user in a company.
+func companyWebhooks(tx *gorm.DB, companyID uint) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	err := tx.Joins("JOIN users ON users.id = webhooks.user_id AND users.deleted_at IS NULL").
+		Where("users.company_id = ?", companyID).
+		Find(&webhooks).Error
+	return webhooks, err
+}
+
+// notifySensor queues a payload about a sensor for every webhook of its
+// company, unless the sensor is in a maintenance window. tx should be the
+// transaction that records the change being reported.
+func notifySensor(tx *gorm.DB, sensor models.Sensor, payload map[string]interface{}) error {
+	n := newNotification(sensor, payload)
+	maintenance, err := activeMaintenance([]models.Sensor{sensor}, time.Now())
+	if err != nil {
+		log.Printf("Error fetching maintenance windows for sensor %d: %v", sensor.ID, err)
+	} else if window := maintenance[sensor.ID]; window != nil {
+		log.Printf("Suppressing %v notification for sensor %d, in maintenance window %d", payload["kind"], sensor.ID, window.ID)
+		return nil
+	}
+
+	webhooks, err := companyWebhooks(tx, sensor.CompanyID)
+	if err != nil {
+		return fmt.Errorf("fetching webhooks for company %d: %w", sensor.CompanyID, err)
+	}
+	return enqueueDeliveries(tx, webhooks, n)
+}
+
+// sensorPayload builds a notification about a sensor that is not tied to a
+// weather warning, such as it going offline.
+func sensorPayload(sensor models.Sensor, kind string) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":         kind,
+		"sensor_id":    sensor.ID,
+		"sensor_name":  sensor.Name,
+		"status":       sensor.Status,
+		"last_seen_at": sensor.LastSeenAt,
+		"timestamp":    time.Now(),
+	}
+}
+
+// resolvedPayload builds the notification for a sensor whose warning status
+// was lowered from previous to its current status.
+func resolvedPayload(sensor models.Sensor, previous string) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":            notificationResolved,
+		"sensor_id":       sensor.ID,
+		"sensor_name":     sensor.Name,
+		"status":          sensor.Status,
+		"previous_status": previous,
+		"timestamp":       time.Now(),
+	}
+}
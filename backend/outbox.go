@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"github.com/Rohnson95/weathermonitoring/backend/webhooksig"
+	"gorm.io/gorm"
+)
+
+// Delivery states.
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+const (
+	// defaultWebhookMaxAttempts is used when WEBHOOK_MAX_ATTEMPTS is not set.
+	defaultWebhookMaxAttempts = 8
+	// defaultWebhookWorkers is used when WEBHOOK_WORKERS is not set.
+	defaultWebhookWorkers = 4
+	// webhookPollInterval is how often the outbox is checked for due
+	// deliveries when nothing has been enqueued in between.
+	webhookPollInterval = 5 * time.Second
+	// webhookClaimLease is how long a claimed delivery is hidden from other
+	// claims. A delivery whose worker died is retried once it runs out.
+	webhookClaimLease  = 2 * time.Minute
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = time.Hour
+)
+
+// webhookMaxAttempts is set in main from WEBHOOK_MAX_ATTEMPTS.
+var webhookMaxAttempts = defaultWebhookMaxAttempts
+
+// webhookWake nudges the dispatcher after deliveries are enqueued.
+var webhookWake = make(chan struct{}, 1)
+
+func wakeWebhookDispatcher() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueDeliveries writes a delivery of the notification for each webhook
+// whose filters accept it. It must be called with the transaction that
+// records the change being reported, so a notification is stored if and
+// only if the change is.
+func enqueueDeliveries(tx *gorm.DB, webhooks []models.Webhook, n notification) error {
+	deliveries, err := newDeliveries(webhooks, n, time.Now())
+	if err != nil {
+		return err
+	}
+	return insertDeliveries(tx, deliveries)
+}
+
+// newDeliveries renders a pending delivery of the notification for each
+// webhook whose filters accept it, without storing them.
+func newDeliveries(webhooks []models.Webhook, n notification, now time.Time) ([]models.WebhookDelivery, error) {
+	webhooks = filterWebhooks(webhooks, n)
+	if len(webhooks) == 0 {
+		return nil, nil
+	}
+
+	// Payloads are rendered once per format and language
+	rendered := make(map[string][]byte)
+	deliveries := make([]models.WebhookDelivery, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		key := webhook.Format + "/" + webhook.Language
+		data, ok := rendered[key]
+		if !ok {
+			var err error
+			if data, err = json.Marshal(renderPayload(n, webhook.Format, webhook.Language)); err != nil {
+				return nil, err
+			}
+			rendered[key] = data
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			DeliveryID:    newDeliveryID(),
+			Kind:          n.Kind,
+			Payload:       data,
+			State:         deliveryPending,
+			NextAttemptAt: now,
+		})
+	}
+	return deliveries, nil
+}
+
+// insertDeliveries stores rendered deliveries in batches and wakes the
+// dispatcher.
+func insertDeliveries(tx *gorm.DB, deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := tx.CreateInBatches(&deliveries, 500).Error; err != nil {
+		return err
+	}
+	// Deliveries that are not yet committed are simply picked up on a later poll
+	wakeWebhookDispatcher()
+	return nil
+}
+
+// webhookBackoff returns a jittered delay before retry number attempt,
+// doubling from webhookBaseBackoff up to webhookMaxBackoff.
+func webhookBackoff(attempt int) time.Duration {
+	delay := webhookMaxBackoff
+	if attempt >= 1 {
+		if d := webhookBaseBackoff << (attempt - 1); d > 0 && d < webhookMaxBackoff {
+			delay = d
+		}
+	}
+	// Spread retries over [delay/2, delay)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
+// claimDeliveries takes up to limit due deliveries, counting the attempt and
+// pushing their next attempt past the claim lease. SKIP LOCKED lets several
+// processes share the outbox.
+func claimDeliveries(limit int, now time.Time) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := db.Raw(`UPDATE webhook_deliveries
+		SET attempts = attempts + 1, next_attempt_at = ?, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE state = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED)
+		RETURNING *`,
+		now.Add(webhookClaimLease), now, deliveryPending, now, limit).
+		Scan(&deliveries).Error
+	return deliveries, err
+}
+
+// runWebhookDispatcher feeds due deliveries to a pool of workers until the
+// process exits.
+func runWebhookDispatcher(workers int) {
+	jobs := make(chan models.WebhookDelivery)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for delivery := range jobs {
+				deliverWebhook(delivery)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	batch := workers * 4
+	for {
+		deliveries, err := claimDeliveries(batch, time.Now())
+		if err != nil {
+			log.Printf("Error claiming webhook deliveries: %v", err)
+		}
+		for _, delivery := range deliveries {
+			jobs <- delivery
+		}
+		if len(deliveries) == batch {
+			continue
+		}
+		select {
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+	}
+}
+
+// postWebhook sends a signed delivery and returns the response status.
+func postWebhook(webhook models.Webhook, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	now := time.Now()
+	webhooksig.SetHeaders(req.Header, deliveryID, now, body, webhookSecrets(webhook, now)...)
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// deliverWebhook makes one attempt at a claimed delivery and records the
+// outcome. Failed deliveries are retried with backoff until
+// webhookMaxAttempts, then moved to the dead state.
+func deliverWebhook(delivery models.WebhookDelivery) {
+	var webhook models.Webhook
+	var status int
+	started := time.Now()
+	err := db.First(&webhook, delivery.WebhookID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Nothing left to deliver to, retrying would not help
+		delivery.Attempts = webhookMaxAttempts
+		err = errors.New("webhook deleted")
+	} else if err == nil {
+		status, err = postWebhook(webhook, delivery.DeliveryID, delivery.Payload)
+	}
+
+	attempt := models.WebhookAttempt{
+		WebhookDeliveryID: delivery.ID,
+		Attempt:           delivery.Attempts,
+		ResponseStatus:    status,
+		LatencyMs:         time.Since(started).Milliseconds(),
+		AttemptedAt:       started,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	if err := db.Create(&attempt).Error; err != nil {
+		log.Printf("Error logging webhook attempt for %s: %v", delivery.DeliveryID, err)
+	}
+
+	updates := deliveryUpdates(delivery.Attempts, status, err, time.Now())
+	if updates["state"] == deliveryDead {
+		log.Printf("Webhook delivery %s dead after %d attempts: %v", delivery.DeliveryID, delivery.Attempts, err)
+	}
+	if err := db.Model(&models.WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error; err != nil {
+		log.Printf("Error recording webhook delivery %s: %v", delivery.DeliveryID, err)
+	}
+}
+
+// deliveryUpdates returns the columns recording the outcome of a delivery's
+// attempts-th attempt, err being why it failed. A failed delivery stays
+// pending with a backed-off next attempt until webhookMaxAttempts.
+func deliveryUpdates(attempts, status int, err error, now time.Time) map[string]interface{} {
+	updates := map[string]interface{}{"last_status": status, "last_error": "", "updated_at": now}
+	switch {
+	case err == nil:
+		updates["state"] = deliveryDelivered
+		updates["delivered_at"] = now
+	case attempts >= webhookMaxAttempts:
+		updates["state"] = deliveryDead
+		updates["last_error"] = err.Error()
+	default:
+		updates["next_attempt_at"] = now.Add(webhookBackoff(attempts))
+		updates["last_error"] = err.Error()
+	}
+	return updates
+}
+
+// userDeliveries scopes a delivery query to the webhooks of a user.
+func userDeliveries(userID uint) *gorm.DB {
+	return db.Model(&models.WebhookDelivery{}).
+		Where("webhook_id IN (?)", db.Model(&models.Webhook{}).Select("id").Where("user_id = ?", userID))
+}
+
+// DeadLettersHandler serves GET /api/webhooks/dead-letters, the user's
+// deliveries that ran out of attempts, newest first.
+func DeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	q := userDeliveries(userID).Where("state = ?", deliveryDead)
+	if webhookID := r.URL.Query().Get("webhookId"); webhookID != "" {
+		q = q.Where("webhook_id = ?", webhookID)
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	if err := q.Order("updated_at DESC, id DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		log.Printf("Error fetching dead letters: %v", err)
+		http.Error(w, "Error fetching dead letters", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// ReplayDeadLetterHandler serves POST /api/webhooks/dead-letters/{id}/replay,
+// putting a dead delivery back in the queue with a fresh attempt budget.
+func ReplayDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	result := userDeliveries(userID).
+		Where("id = ? AND state = ?", r.PathValue("id"), deliveryDead).
+		Updates(map[string]interface{}{"state": deliveryPending, "attempts": 0, "next_attempt_at": now, "updated_at": now})
+	if result.Error != nil {
+		log.Printf("Error replaying dead letter: %v", result.Error)
+		http.Error(w, "Error replaying delivery", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+	wakeWebhookDispatcher()
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		delay   time.Duration // Retries are spread over [delay/2, delay)
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{20, time.Hour},
+		{100, time.Hour},
+		{0, time.Hour},
+	}
+	for _, tt := range tests {
+		for range 200 {
+			if d := webhookBackoff(tt.attempt); d < tt.delay/2 || d >= tt.delay {
+				t.Fatalf("attempt %d: backoff %s outside [%s, %s)", tt.attempt, d, tt.delay/2, tt.delay)
+			}
+		}
+	}
+}
+
+func TestDeliveryUpdates(t *testing.T) {
+	defer func(max int) { webhookMaxAttempts = max }(webhookMaxAttempts)
+	webhookMaxAttempts = 3
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	failed := errors.New("webhook responded with status 500")
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		state    interface{} // nil keeps the delivery pending
+		retryIn  time.Duration
+	}{
+		{"first attempt delivered", 1, nil, deliveryDelivered, 0},
+		{"first failure is retried", 1, failed, nil, 30 * time.Second},
+		{"later failure backs off further", 2, failed, nil, time.Minute},
+		{"last attempt delivered", 3, nil, deliveryDelivered, 0},
+		{"last attempt failed", 3, failed, deliveryDead, 0},
+		{"deleted webhook", 8, errors.New("webhook deleted"), deliveryDead, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates := deliveryUpdates(tt.attempts, 500, tt.err, now)
+			if updates["state"] != tt.state {
+				t.Errorf("state %v, want %v", updates["state"], tt.state)
+			}
+			next, retried := updates["next_attempt_at"].(time.Time)
+			if retried != (tt.retryIn > 0) {
+				t.Fatalf("next_attempt_at %v, want a retry %v", updates["next_attempt_at"], tt.retryIn > 0)
+			}
+			if retried && (next.Before(now.Add(tt.retryIn/2)) || !next.Before(now.Add(tt.retryIn))) {
+				t.Errorf("retry at %v, want within [%s, %s)", next.Sub(now), tt.retryIn/2, tt.retryIn)
+			}
+			if wantError := tt.err != nil; (updates["last_error"] != "") != wantError {
+				t.Errorf("last_error %q", updates["last_error"])
+			}
+			if _, delivered := updates["delivered_at"]; delivered != (tt.state == deliveryDelivered) {
+				t.Errorf("delivered_at set %v", delivered)
+			}
+		})
+	}
+}
+
+// TestWebhookOutbox needs TEST_DATABASE_URL.
+func TestWebhookOutbox(t *testing.T) {
+	openTestDB(t)
+	defer func(max int) { webhookMaxAttempts = max }(webhookMaxAttempts)
+	webhookMaxAttempts = 2
+
+	var mu sync.Mutex
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	respond := func(code int) {
+		mu.Lock()
+		status = code
+		mu.Unlock()
+	}
+
+	company := models.Company{Name: "Outbox"}
+	if err := db.Create(&company).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Email: "outbox@example.com", PasswordHash: "x", CompanyID: company.ID}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	webhook := models.Webhook{URL: server.URL, UserID: user.ID, Secret: "secret"}
+	if err := db.Create(&webhook).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	delivery := func(state string, next time.Time) *models.WebhookDelivery {
+		d := &models.WebhookDelivery{
+			WebhookID: webhook.ID, DeliveryID: newDeliveryID(), Kind: "warning",
+			Payload: models.JSON(`{}`), State: state, NextAttemptAt: next,
+		}
+		if err := db.Create(d).Error; err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	due := delivery(deliveryPending, now.Add(-time.Minute))
+	delivery(deliveryPending, now.Add(time.Hour))
+	delivery(deliveryDead, now.Add(-time.Minute))
+	delivery(deliveryDelivered, now.Add(-time.Minute))
+
+	load := func() models.WebhookDelivery {
+		var stored models.WebhookDelivery
+		if err := db.First(&stored, due.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+	claim := func() []models.WebhookDelivery {
+		claimed, err := claimDeliveries(10, time.Now())
+		if err != nil {
+			t.Fatalf("claiming: %v", err)
+		}
+		return claimed
+	}
+
+	// Only the due pending delivery is claimed, and only once
+	claimed := claim()
+	if len(claimed) != 1 || claimed[0].ID != due.ID || claimed[0].Attempts != 1 {
+		t.Fatalf("claimed %+v, want delivery %d on attempt 1", claimed, due.ID)
+	}
+	if again := claim(); len(again) != 0 {
+		t.Fatalf("claimed %d leased deliveries", len(again))
+	}
+
+	// A failure is retried later
+	deliverWebhook(claimed[0])
+	stored := load()
+	if stored.State != deliveryPending || stored.LastStatus != 500 || stored.LastError == "" ||
+		!stored.NextAttemptAt.After(time.Now()) {
+		t.Fatalf("after a failure: %+v", stored)
+	}
+
+	// The last failure dead-letters it
+	db.Model(&stored).Update("next_attempt_at", time.Now().Add(-time.Second))
+	claimed = claim()
+	if len(claimed) != 1 || claimed[0].Attempts != 2 {
+		t.Fatalf("claimed %+v, want attempt 2", claimed)
+	}
+	deliverWebhook(claimed[0])
+	if stored = load(); stored.State != deliveryDead {
+		t.Fatalf("after the last attempt: state %s", stored.State)
+	}
+	if again := claim(); len(again) != 0 {
+		t.Fatalf("claimed %d dead deliveries", len(again))
+	}
+
+	// Replayed, it gets a fresh budget and is delivered
+	db.Model(&stored).Updates(map[string]interface{}{"state": deliveryPending, "attempts": 0, "next_attempt_at": time.Now()})
+	respond(http.StatusNoContent)
+	claimed = claim()
+	if len(claimed) != 1 || claimed[0].Attempts != 1 {
+		t.Fatalf("claimed %+v after replay, want attempt 1", claimed)
+	}
+	deliverWebhook(claimed[0])
+	if stored = load(); stored.State != deliveryDelivered || stored.DeliveredAt == nil || stored.LastError != "" {
+		t.Fatalf("after delivery: %+v", stored)
+	}
+
+	var attempts []models.WebhookAttempt
+	db.Where("webhook_delivery_id = ?", due.ID).Order("id").Find(&attempts)
+	if len(attempts) != 3 || attempts[0].ResponseStatus != 500 || attempts[2].ResponseStatus != 204 {
+		t.Errorf("attempt log %+v", attempts)
+	}
+}
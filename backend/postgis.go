@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"gorm.io/gorm"
+)
+
+// postgisEnabled is set at startup when POSTGIS_ENABLED is true and the
+// extension could be loaded. Otherwise sensors are matched in-process.
+var postgisEnabled bool
+
+// enablePostGIS loads the PostGIS extension and prepares the spatial columns.
+// Any failure leaves PostGIS mode off so the in-process matcher is used.
+func enablePostGIS() {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS postgis").Error; err != nil {
+		log.Printf("PostGIS unavailable, using in-process matcher: %v", err)
+		return
+	}
+
+	// The sensor location is generated from latitude/longitude so it never
+	// drifts from the columns the handlers write.
+	stmts := []string{
+		`ALTER TABLE sensors ADD COLUMN IF NOT EXISTS location geography(Point, 4326)
+			GENERATED ALWAYS AS (ST_SetSRID(ST_MakePoint(longitude, latitude), 4326)::geography) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_sensors_location ON sensors USING GIST (location)`,
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Error preparing sensor locations, using in-process matcher: %v", err)
+			return
+		}
+	}
+
+	if err := db.AutoMigrate(&models.WarningAreaGeometry{}); err != nil {
+		log.Printf("Error migrating warning area geometries, using in-process matcher: %v", err)
+		return
+	}
+	if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_warning_area_geometries_geom ON warning_area_geometries USING GIST (geom)`).Error; err != nil {
+		log.Printf("Error indexing warning area geometries, using in-process matcher: %v", err)
+		return
+	}
+
+	postgisEnabled = true
+	log.Println("PostGIS mode enabled")
+}
+
+// storeWarningGeometries replaces the stored warning areas with the ones in
+// the index so the database matches what is cached in memory.
+func storeWarningGeometries(index *warningIndex) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM warning_area_geometries").Error; err != nil {
+			return err
+		}
+		for _, a := range index.areas {
+			geometry, err := json.Marshal(a.Area.Area.Geometry)
+			if err != nil {
+				return fmt.Errorf("warning %d area %d: %w", a.Warning.ID, a.Area.ID, err)
+			}
+			err = tx.Exec(`INSERT INTO warning_area_geometries (warning_id, area_id, geom)
+				VALUES (?, ?, ST_Multi(ST_SetSRID(ST_GeomFromGeoJSON(?), 4326))::geography)`,
+				a.Warning.ID, a.Area.ID, string(geometry)).Error
+			if err != nil {
+				return fmt.Errorf("warning %d area %d: %w", a.Warning.ID, a.Area.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// matchSensorsPostGIS finds every sensor inside, or within its alert radius
+// of, a stored warning area with a single spatial join. Both sides are
+// geography so the join can use either GIST index, casting an indexed
+// column would rule it out.
+func matchSensorsPostGIS(index *warningIndex) (map[uint][]areaMatch, error) {
+	var rows []struct {
+		SensorID   uint
+		WarningID  int
+		AreaID     int
+		Inside     bool
+		DistanceKm float64
+	}
+	err := db.Raw(`SELECT s.id AS sensor_id, g.warning_id, g.area_id,
+			ST_Intersects(g.geom, s.location) AS inside,
+			ST_Distance(g.geom, s.location) / 1000 AS distance_km
+		FROM sensors s
+		JOIN companies c ON c.id = s.company_id
+		JOIN warning_area_geometries g ON ST_DWithin(g.geom, s.location,
+			COALESCE(NULLIF(s.alert_radius_km, 0), c.alert_radius_km, 0) * 1000)
+		WHERE s.deleted_at IS NULL`).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make(map[uint][]areaMatch)
+	for _, row := range rows {
+		area := index.lookup(areaKey{WarningID: row.WarningID, AreaID: row.AreaID})
+		if area == nil {
+			continue
+		}
+		match := areaMatch{indexedArea: area, Proximity: proximityInside}
+		if !row.Inside {
+			match.Proximity = proximityNear
+			match.DistanceKm = row.DistanceKm
+		}
+		matches[row.SensorID] = append(matches[row.SensorID], match)
+	}
+	return matches, nil
+}
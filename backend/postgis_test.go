@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+// matchedAreas lists matches as sorted "sensor:warning/area" keys.
+func matchedAreas(matches map[uint][]areaMatch) []string {
+	var keys []string
+	for sensorID, list := range matches {
+		for _, match := range list {
+			keys = append(keys, fmt.Sprintf("%d:%d/%d", sensorID, match.Warning.ID, match.Area.ID))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TestMatchSensorsPostGIS checks that the database agrees with the in-process
+// matcher, and that a fetch whose areas could not be stored is matched
+// in-process rather than against the previous fetch's rows. It needs
+// TEST_DATABASE_URL and is skipped when PostGIS cannot be loaded.
+func TestMatchSensorsPostGIS(t *testing.T) {
+	openTestDB(t)
+	defer func(enabled bool) { postgisEnabled = enabled }(postgisEnabled)
+	enablePostGIS()
+	if !postgisEnabled {
+		t.Skip("PostGIS not available")
+	}
+
+	company := models.Company{Name: "Gotland Energi", AlertRadiusKm: 10}
+	if err := db.Create(&company).Error; err != nil {
+		t.Fatal(err)
+	}
+	sensors := []models.Sensor{
+		{Name: "Visby", Latitude: 57.63, Longitude: 18.29, Status: statusOK, CompanyID: company.ID},
+		{Name: "Stockholm", Latitude: 59.33, Longitude: 18.07, Status: statusOK, CompanyID: company.ID},
+		{Name: "Off Gotland", Latitude: 57.0, Longitude: 18.05, Status: statusOK, CompanyID: company.ID},
+	}
+	if err := db.Create(&sensors).Error; err != nil {
+		t.Fatal(err)
+	}
+	companies := map[uint]models.Company{company.ID: company}
+
+	gotland := WarningArea{ID: 1, Area: decodeFeature(t, gotlandArea), WarningLevel: WarningLevel{Code: "RED"}}
+	index := setCachedWarnings([]Warning{{ID: 1, WarningAreas: []WarningArea{gotland}}})
+	if !index.stored {
+		t.Fatal("areas were not stored")
+	}
+	got, want := matchSensors(sensors, companies, index), matchSensorsInProcess(sensors, companies, index)
+	if !reflect.DeepEqual(matchedAreas(got), matchedAreas(want)) {
+		t.Fatalf("PostGIS matched %v, in-process %v", matchedAreas(got), matchedAreas(want))
+	}
+	if len(got[sensors[0].ID]) != 1 || got[sensors[0].ID][0].Proximity != proximityInside {
+		t.Errorf("Visby matched %+v, want inside Gotland", got[sensors[0].ID])
+	}
+
+	// PostGIS rejects the unclosed ring, so the whole store is rolled back and
+	// the table still holds Gotland
+	stockholm := WarningArea{ID: 1, WarningLevel: WarningLevel{Code: "YELLOW"}}
+	stockholm.Area.Geometry.Type = "Polygon"
+	stockholm.Area.Geometry.Coordinates = []interface{}{[]interface{}{
+		[]interface{}{17.8, 59.2}, []interface{}{18.4, 59.2}, []interface{}{18.4, 59.5}, []interface{}{17.8, 59.5},
+	}}
+	index = setCachedWarnings([]Warning{{ID: 2, WarningAreas: []WarningArea{stockholm}}})
+	if index.stored {
+		t.Fatal("store of an unclosed ring succeeded")
+	}
+	got = matchSensors(sensors, companies, index)
+	if len(got[sensors[0].ID]) != 0 {
+		t.Errorf("Visby matched the previous fetch: %+v", got[sensors[0].ID])
+	}
+	if len(got[sensors[1].ID]) != 1 || got[sensors[1].ID][0].Warning.ID != 2 {
+		t.Errorf("Stockholm matched %+v, want warning 2", got[sensors[1].ID])
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WarningProvider supplies snapshots of the currently active weather warnings.
+type WarningProvider interface {
+	FetchWarnings(ctx context.Context) ([]Warning, error)
+	Name() string
+}
+
+// warningProvider is the source used by fetchWeatherWarnings. It is set in
+// main from WARNINGS_PROVIDER.
+var warningProvider WarningProvider
+
+// newWarningProvider builds the provider selected by kind. An empty kind
+// selects the SMHI provider.
+func newWarningProvider(kind, smhiURL, filePath string) (WarningProvider, error) {
+	switch kind {
+	case "", "smhi":
+		if smhiURL == "" {
+			return nil, fmt.Errorf("SMHI_API_URL is required for the smhi provider")
+		}
+		return newSMHIProvider(smhiURL), nil
+	case "file":
+		return newFileProvider(filePath)
+	default:
+		return nil, fmt.Errorf("unknown warnings provider %q", kind)
+	}
+}
+
+// Retry and timeout settings for the SMHI provider.
+const (
+	smhiRequestTimeout = 30 * time.Second
+	smhiMaxAttempts    = 4
+	smhiBaseBackoff    = time.Second
+	smhiMaxBackoff     = 30 * time.Second
+)
+
+// smhiProvider reads warnings from the SMHI IBWW warning.json endpoint. It
+// retries transient failures with jittered exponential backoff and uses
+// conditional requests, returning the previous snapshot when SMHI reports
+// it unchanged.
+type smhiProvider struct {
+	url         string
+	client      *http.Client
+	baseBackoff time.Duration
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	last         []Warning
+}
+
+func newSMHIProvider(url string) *smhiProvider {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          4,
+	}
+	return &smhiProvider{
+		url:         url,
+		client:      &http.Client{Timeout: smhiRequestTimeout, Transport: transport},
+		baseBackoff: smhiBaseBackoff,
+	}
+}
+
+func (p *smhiProvider) Name() string { return "smhi" }
+
+// retryableError marks failures worth another attempt, such as network
+// errors, 429 and 5xx responses.
+type retryableError struct{ err error }
+
+func (e retryableError) Error() string { return e.err.Error() }
+func (e retryableError) Unwrap() error { return e.err }
+
+func (p *smhiProvider) FetchWarnings(ctx context.Context) ([]Warning, error) {
+	var err error
+	for attempt := 0; attempt < smhiMaxAttempts; attempt++ {
+		if attempt > 0 {
+			delay := backoffDelay(p.baseBackoff, attempt)
+			log.Printf("Retrying SMHI fetch in %s (attempt %d/%d): %v", delay, attempt+1, smhiMaxAttempts, err)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		var warnings []Warning
+		warnings, err = p.fetchOnce(ctx)
+		if err == nil {
+			return warnings, nil
+		}
+		var retryable retryableError
+		if !errors.As(err, &retryable) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// backoffDelay returns a random delay up to base*2^attempt, capped at
+// smhiMaxBackoff ("full jitter").
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	ceiling := base << attempt
+	if ceiling <= 0 || ceiling > smhiMaxBackoff {
+		ceiling = smhiMaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+func (p *smhiProvider) fetchOnce(ctx context.Context) ([]Warning, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	p.mu.Lock()
+	if p.last != nil {
+		if p.etag != "" {
+			req.Header.Set("If-None-Match", p.etag)
+		}
+		if p.lastModified != "" {
+			req.Header.Set("If-Modified-Since", p.lastModified)
+		}
+	}
+	p.mu.Unlock()
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, retryableError{fmt.Errorf("failed to fetch warnings: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.last, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, retryableError{fmt.Errorf("SMHI responded with status %d", resp.StatusCode)}
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("SMHI responded with status %d", resp.StatusCode)
+	}
+
+	var warnings []Warning
+	if err := json.NewDecoder(resp.Body).Decode(&warnings); err != nil {
+		return nil, retryableError{fmt.Errorf("failed to decode warnings: %w", err)}
+	}
+
+	p.mu.Lock()
+	p.etag = resp.Header.Get("ETag")
+	p.lastModified = resp.Header.Get("Last-Modified")
+	p.last = warnings
+	p.mu.Unlock()
+	return warnings, nil
+}
+
+// fileProvider replays warning snapshots recorded as SMHI warning.json files.
+// Given a directory it returns the files in name order, one per fetch, and
+// keeps returning the last one once the sequence is exhausted.
+type fileProvider struct {
+	mu    sync.Mutex
+	paths []string
+	next  int
+}
+
+func newFileProvider(path string) (*fileProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("WARNINGS_FILE_PATH is required for the file provider")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return &fileProvider{paths: []string{path}}, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.json snapshots in %s", path)
+	}
+	sort.Strings(paths)
+	return &fileProvider{paths: paths}, nil
+}
+
+func (p *fileProvider) Name() string { return "file" }
+
+func (p *fileProvider) FetchWarnings(ctx context.Context) ([]Warning, error) {
+	p.mu.Lock()
+	path := p.paths[p.next]
+	if p.next < len(p.paths)-1 {
+		p.next++
+	}
+	p.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	var warnings []Warning
+	if err := json.Unmarshal(data, &warnings); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %s: %w", path, err)
+	}
+	return warnings, nil
+}
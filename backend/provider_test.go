@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const providerTestBody = `[{"id":1,"event":{"en":"Wind","sv":"Vind","code":"WIND"},"warningAreas":[{"id":10,
+	"area":{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[18,57],[19,57],[19,58],[18,57]]]}}}]}]`
+
+// testSMHIProvider returns a provider for url that backs off in milliseconds.
+func testSMHIProvider(url string) *smhiProvider {
+	p := newSMHIProvider(url)
+	p.baseBackoff = time.Millisecond
+	return p
+}
+
+func TestSMHIProviderConditionalGet(t *testing.T) {
+	var mu sync.Mutex
+	var requests []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Header.Clone())
+		mu.Unlock()
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Wed, 01 Jan 2025 12:00:00 GMT")
+		w.Write([]byte(providerTestBody))
+	}))
+	defer server.Close()
+
+	p := testSMHIProvider(server.URL)
+	first, err := p.FetchWarnings(context.Background())
+	if err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	if len(first) != 1 || first[0].ID != 1 || first[0].Event.Code != "WIND" {
+		t.Fatalf("first fetch returned %+v", first)
+	}
+	second, err := p.FetchWarnings(context.Background())
+	if err != nil {
+		t.Fatalf("second fetch: %v", err)
+	}
+	if !reflect.DeepEqual(second, first) {
+		t.Errorf("304 returned %+v, want the cached %+v", second, first)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(requests))
+	}
+	if requests[0].Get("If-None-Match") != "" || requests[0].Get("If-Modified-Since") != "" {
+		t.Errorf("first request was conditional: %v", requests[0])
+	}
+	if got := requests[1].Get("If-Modified-Since"); got != "Wed, 01 Jan 2025 12:00:00 GMT" {
+		t.Errorf("If-Modified-Since %q", got)
+	}
+}
+
+func TestSMHIProviderRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []int // Status of each response, 200 serves providerTestBody
+		requests  int
+		wantErr   bool
+	}{
+		{"success", []int{200}, 1, false},
+		{"5xx is retried", []int{500, 503, 200}, 3, false},
+		{"429 is retried", []int{429, 200}, 2, false},
+		{"gives up after the last attempt", []int{502, 502, 502, 502, 200}, smhiMaxAttempts, true},
+		{"404 is not retried", []int{404, 200}, 1, true},
+		{"400 is not retried", []int{400, 200}, 1, true},
+		{"undecodable body is retried", []int{-1, 200}, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				status := tt.responses[min(requests, len(tt.responses)-1)]
+				requests++
+				mu.Unlock()
+				switch status {
+				case http.StatusOK:
+					w.Write([]byte(providerTestBody))
+				case -1:
+					w.Write([]byte(`[{"id":`))
+				default:
+					w.WriteHeader(status)
+				}
+			}))
+			defer server.Close()
+
+			warnings, err := testSMHIProvider(server.URL).FetchWarnings(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(warnings) != 1 {
+				t.Errorf("got %d warnings, want 1", len(warnings))
+			}
+			if requests != tt.requests {
+				t.Errorf("%d requests, want %d", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestSMHIProviderCancelledDuringBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	p := newSMHIProvider(server.URL)
+	p.baseBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.FetchWarnings(ctx); err != context.DeadlineExceeded {
+		t.Errorf("error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt := 1; attempt < 10; attempt++ {
+		ceiling := min(time.Second<<attempt, smhiMaxBackoff)
+		for range 100 {
+			if d := backoffDelay(time.Second, attempt); d < 0 || d >= ceiling {
+				t.Fatalf("attempt %d: delay %s outside [0, %s)", attempt, d, ceiling)
+			}
+		}
+	}
+}
+
+func TestFileProviderMatchesHTTP(t *testing.T) {
+	const fixture = "testdata/warnings/01-wind.json"
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	fromHTTP, err := testSMHIProvider(server.URL).FetchWarnings(context.Background())
+	if err != nil {
+		t.Fatalf("HTTP fetch: %v", err)
+	}
+	files, err := newFileProvider(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := files.FetchWarnings(context.Background())
+	if err != nil {
+		t.Fatalf("file fetch: %v", err)
+	}
+	if !reflect.DeepEqual(fromFile, fromHTTP) {
+		t.Errorf("file provider returned\n%+v\nHTTP provider returned\n%+v", fromFile, fromHTTP)
+	}
+
+	if len(fromFile) != 2 {
+		t.Fatalf("got %d warnings, want 2", len(fromFile))
+	}
+	wind, fire := fromFile[0], fromFile[1]
+	if wind.ID != 1201 || wind.Event.Code != "WIND" || len(wind.WarningAreas) != 1 {
+		t.Errorf("wind warning parsed as %+v", wind)
+	}
+	area := wind.WarningAreas[0]
+	if area.WarningLevel.Code != "ORANGE" || area.ApproximateEnd != "2025-01-15T12:00:00.000Z" ||
+		len(area.AffectedAreas) != 1 || area.AffectedAreas[0].Sv != "Gotlands län" {
+		t.Errorf("wind area parsed as %+v", area)
+	}
+	if fire.WarningAreas[0].ApproximateEnd != "" || len(fire.WarningAreas[0].AffectedAreas) != 2 {
+		t.Errorf("fire area parsed as %+v", fire.WarningAreas[0])
+	}
+
+	// Both geometries decode and are matched like live ones
+	index := buildWarningIndex(fromFile)
+	if len(index.areas) != 2 {
+		t.Fatalf("indexed %d areas, want 2", len(index.areas))
+	}
+	if matches := index.query(57.64, 18.3, 0); len(matches) != 1 || matches[0].Warning.ID != 1201 {
+		t.Errorf("Visby matched %d areas", len(matches))
+	}
+	if matches := index.query(59.86, 17.64, 0); len(matches) != 1 || matches[0].Warning.ID != 1202 {
+		t.Errorf("Uppsala matched %d areas", len(matches))
+	}
+}
+
+func TestFileProviderReplaysDirectory(t *testing.T) {
+	p, err := newFileProvider("testdata/warnings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The snapshots in name order, then the last one again
+	for i, want := range [][]int{{1201, 1202}, {1202}, {1202}} {
+		warnings, err := p.FetchWarnings(context.Background())
+		if err != nil {
+			t.Fatalf("fetch %d: %v", i+1, err)
+		}
+		var got []int
+		for _, warning := range warnings {
+			got = append(got, warning.ID)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("fetch %d returned warnings %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestNewFileProviderErrors(t *testing.T) {
+	for _, path := range []string{"", "testdata/warnings/missing.json", t.TempDir()} {
+		if _, err := newFileProvider(path); err == nil {
+			t.Errorf("newFileProvider(%q) succeeded", path)
+		}
+	}
+}
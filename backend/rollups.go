@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+// rollupLevel is one rollup resolution. Each level is built from the one
+// below it, raw readings for the finest.
+type rollupLevel struct {
+	Name      string
+	Size      time.Duration
+	TruncUnit string // date_trunc unit
+}
+
+var rollupLevels = []rollupLevel{
+	{Name: "1m", Size: time.Minute, TruncUnit: "minute"},
+	{Name: "1h", Size: time.Hour, TruncUnit: "hour"},
+	{Name: "1d", Size: 24 * time.Hour, TruncUnit: "day"},
+}
+
+const (
+	// rollupInterval is how often rollups are refreshed.
+	rollupInterval = time.Minute
+	// rollupLookback is always recomputed so readings arriving slightly late
+	// are included even if nothing marked them dirty.
+	rollupLookback = 10 * time.Minute
+	// maxAggregatePoints bounds the size of an aggregate query response.
+	maxAggregatePoints = 5000
+	// defaultAggregatePoints is used to pick a step when none is given.
+	defaultAggregatePoints = 500
+
+	defaultReadingsRetention     = 30 * 24 * time.Hour
+	defaultMinuteRollupRetention = 90 * 24 * time.Hour
+)
+
+// Retention periods, set in main. Hourly and daily rollups are kept forever.
+var (
+	readingsRetention     = defaultReadingsRetention
+	minuteRollupRetention = defaultMinuteRollupRetention
+)
+
+// rollupDirty holds the earliest reading timestamp stored since the last
+// rollup run, so backfilled data is rolled up regardless of its age.
+var (
+	rollupDirtyMutex sync.Mutex
+	rollupDirtyFrom  *time.Time
+)
+
+func markRollupDirty(readings []models.Reading) {
+	rollupDirtyMutex.Lock()
+	defer rollupDirtyMutex.Unlock()
+	for _, reading := range readings {
+		if rollupDirtyFrom == nil || reading.RecordedAt.Before(*rollupDirtyFrom) {
+			t := reading.RecordedAt
+			rollupDirtyFrom = &t
+		}
+	}
+}
+
+func takeRollupDirty() *time.Time {
+	rollupDirtyMutex.Lock()
+	defer rollupDirtyMutex.Unlock()
+	from := rollupDirtyFrom
+	rollupDirtyFrom = nil
+	return from
+}
+
+// runRollups recomputes every rollup bucket from `from` onwards.
+func runRollups(from time.Time) error {
+	for i, level := range rollupLevels {
+		start := from.UTC().Truncate(level.Size)
+		var err error
+		if i == 0 {
+			err = db.Exec(`INSERT INTO reading_rollups (sensor_id, metric, resolution, bucket_start, min, max, sum, count)
+				SELECT sensor_id, metric, ?, date_trunc(?, recorded_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC',
+					MIN(value), MAX(value), SUM(value), COUNT(*)
+				FROM readings
+				WHERE recorded_at >= ?
+				GROUP BY 1, 2, 3, 4
+				ON CONFLICT (sensor_id, metric, resolution, bucket_start) DO UPDATE
+				SET min = EXCLUDED.min, max = EXCLUDED.max, sum = EXCLUDED.sum, count = EXCLUDED.count`,
+				level.Name, level.TruncUnit, start).Error
+		} else {
+			err = db.Exec(`INSERT INTO reading_rollups (sensor_id, metric, resolution, bucket_start, min, max, sum, count)
+				SELECT sensor_id, metric, ?, date_trunc(?, bucket_start AT TIME ZONE 'UTC') AT TIME ZONE 'UTC',
+					MIN(min), MAX(max), SUM(sum), SUM(count)
+				FROM reading_rollups
+				WHERE resolution = ? AND bucket_start >= ?
+				GROUP BY 1, 2, 3, 4
+				ON CONFLICT (sensor_id, metric, resolution, bucket_start) DO UPDATE
+				SET min = EXCLUDED.min, max = EXCLUDED.max, sum = EXCLUDED.sum, count = EXCLUDED.count`,
+				level.Name, level.TruncUnit, rollupLevels[i-1].Name, start).Error
+		}
+		if err != nil {
+			return fmt.Errorf("%s rollup: %w", level.Name, err)
+		}
+	}
+	return nil
+}
+
+// purgeExpiredReadings applies the retention policies.
+func purgeExpiredReadings(now time.Time) {
+	result := db.Where("recorded_at < ?", now.Add(-readingsRetention)).Delete(&models.Reading{})
+	if result.Error != nil {
+		log.Printf("Error purging raw readings: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("Purged %d raw readings", result.RowsAffected)
+	}
+
+	result = db.Where("resolution = ? AND bucket_start < ?", rollupLevels[0].Name, now.Add(-minuteRollupRetention)).
+		Delete(&models.ReadingRollup{})
+	if result.Error != nil {
+		log.Printf("Error purging minute rollups: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("Purged %d minute rollups", result.RowsAffected)
+	}
+}
+
+// periodicRollups keeps rollups current and purges expired data. The first
+// run covers the whole raw retention period to catch up after downtime.
+func periodicRollups() {
+	from := time.Now().Add(-readingsRetention)
+	lastPurge := time.Time{}
+
+	ticker := time.NewTicker(rollupInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		if dirty := takeRollupDirty(); dirty != nil && dirty.Before(from) {
+			from = *dirty
+		}
+		if err := runRollups(from); err != nil {
+			log.Printf("Error computing rollups: %v", err)
+		} else {
+			from = now.Add(-rollupLookback)
+		}
+
+		if now.Sub(lastPurge) >= time.Hour {
+			purgeExpiredReadings(now)
+			lastPurge = now
+		}
+
+		<-ticker.C
+	}
+}
+
+// aggregatePoint is one step of an aggregate query.
+type aggregatePoint struct {
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Count int64     `json:"count"`
+}
+
+// pickResolution returns the coarsest source whose bucket fits in step and
+// whose retention still covers from. It returns "raw" when step is finer
+// than the smallest rollup, and may widen step when only a coarser level
+// still holds data that old.
+func pickResolution(from time.Time, step time.Duration, now time.Time) (string, time.Duration) {
+	retention := map[string]time.Duration{"raw": readingsRetention, "1m": minuteRollupRetention}
+	covers := func(name string) bool {
+		r, ok := retention[name]
+		return !ok || !from.Before(now.Add(-r))
+	}
+
+	chosen := -1
+	for i, level := range rollupLevels {
+		if level.Size <= step {
+			chosen = i
+		}
+	}
+	if chosen < 0 {
+		if covers("raw") {
+			return "raw", step
+		}
+		chosen = 0
+	}
+	for chosen < len(rollupLevels)-1 && !covers(rollupLevels[chosen].Name) {
+		chosen++
+	}
+	level := rollupLevels[chosen]
+	if step < level.Size {
+		step = level.Size
+	}
+	return level.Name, step
+}
+
+// handleGetAggregates serves GET /api/sensors/{id}/readings/aggregate with
+// metric, from, to and an optional step (Go duration).
+func handleGetAggregates(w http.ResponseWriter, r *http.Request, sensor models.Sensor) {
+	query := r.URL.Query()
+	metric := query.Get("metric")
+	if metric == "" {
+		http.Error(w, "Missing metric", http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(query.Get("from"), query.Get("to"), 24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	step := to.Sub(from) / defaultAggregatePoints
+	if v := query.Get("step"); v != "" {
+		step, err = time.ParseDuration(v)
+		if err != nil || step <= 0 {
+			http.Error(w, "Invalid step", http.StatusBadRequest)
+			return
+		}
+	}
+	if step < time.Second {
+		step = time.Second
+	}
+	resolution, step := pickResolution(from, step, time.Now())
+	if to.Sub(from)/step > maxAggregatePoints {
+		http.Error(w, fmt.Sprintf("Range and step give more than %d points", maxAggregatePoints), http.StatusBadRequest)
+		return
+	}
+
+	seconds := int64(step / time.Second)
+	points := []aggregatePoint{}
+	if resolution == "raw" {
+		err = db.Raw(`SELECT to_timestamp(floor(extract(epoch FROM recorded_at) / ?) * ?) AS time,
+				MIN(value) AS min, MAX(value) AS max, AVG(value) AS avg, COUNT(*) AS count
+			FROM readings
+			WHERE sensor_id = ? AND metric = ? AND recorded_at >= ? AND recorded_at <= ?
+			GROUP BY 1 ORDER BY 1`, seconds, seconds, sensor.ID, metric, from, to).Scan(&points).Error
+	} else {
+		err = db.Raw(`SELECT to_timestamp(floor(extract(epoch FROM bucket_start) / ?) * ?) AS time,
+				MIN(min) AS min, MAX(max) AS max, SUM(sum) / SUM(count) AS avg, SUM(count) AS count
+			FROM reading_rollups
+			WHERE sensor_id = ? AND metric = ? AND resolution = ? AND bucket_start >= ? AND bucket_start <= ?
+			GROUP BY 1 ORDER BY 1`, seconds, seconds, sensor.ID, metric, resolution, from, to).Scan(&points).Error
+	}
+	if err != nil {
+		log.Printf("Error aggregating readings for sensor %d: %v", sensor.ID, err)
+		http.Error(w, "Error fetching aggregates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		SensorID   uint             `json:"sensor_id"`
+		Metric     string           `json:"metric"`
+		Resolution string           `json:"resolution"`
+		Step       string           `json:"step"`
+		Points     []aggregatePoint `json:"points"`
+	}{SensorID: sensor.ID, Metric: metric, Resolution: resolution, Step: step.String(), Points: points})
+}
+
+// AggregatesHandler serves /api/sensors/{id}/readings/aggregate.
+func AggregatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sensor, status, err := findCompanySensor(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	handleGetAggregates(w, r, sensor)
+}
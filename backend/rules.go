@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"gorm.io/gorm"
+)
+
+// Comparators supported by alert rules.
+const (
+	comparatorGT  = "gt"
+	comparatorGTE = "gte"
+	comparatorLT  = "lt"
+	comparatorLTE = "lte"
+)
+
+// Rule transitions reported in threshold notifications.
+const (
+	ruleTriggered = "triggered"
+	ruleResolved  = "resolved"
+)
+
+// ruleEvalMutex serializes rule evaluation so readings arriving at the same
+// time over HTTP and MQTT do not race on the rule state, nor with a rule
+// update clearing it.
+var ruleEvalMutex sync.Mutex
+
+// breaches reports whether a value satisfies the rule condition.
+func ruleBreaches(rule models.AlertRule, value float64) bool {
+	switch rule.Comparator {
+	case comparatorGT:
+		return value > rule.Threshold
+	case comparatorGTE:
+		return value >= rule.Threshold
+	case comparatorLT:
+		return value < rule.Threshold
+	case comparatorLTE:
+		return value <= rule.Threshold
+	default:
+		return false
+	}
+}
+
+// ruleClears reports whether a value is far enough back from the threshold to
+// resolve an active alert.
+func ruleClears(rule models.AlertRule, value float64) bool {
+	switch rule.Comparator {
+	case comparatorGT, comparatorGTE:
+		return value < rule.Threshold-rule.Hysteresis
+	case comparatorLT, comparatorLTE:
+		return value > rule.Threshold+rule.Hysteresis
+	default:
+		return true
+	}
+}
+
+// stepRule advances a rule state by one reading and returns the transition,
+// if any.
+func stepRule(rule models.AlertRule, state *models.AlertRuleState, reading models.Reading) string {
+	state.LastValue = reading.Value
+	state.LastEvaluatedAt = reading.RecordedAt
+
+	if ruleBreaches(rule, reading.Value) {
+		if state.BreachStartedAt == nil {
+			started := reading.RecordedAt
+			state.BreachStartedAt = &started
+		}
+		held := reading.RecordedAt.Sub(*state.BreachStartedAt)
+		if !state.Firing && held >= time.Duration(rule.DurationSeconds)*time.Second {
+			triggered := reading.RecordedAt
+			state.Firing = true
+			state.TriggeredAt = &triggered
+			return ruleTriggered
+		}
+		return ""
+	}
+
+	if state.Firing {
+		if !ruleClears(rule, reading.Value) {
+			return ""
+		}
+		state.Firing = false
+		state.BreachStartedAt = nil
+		return ruleResolved
+	}
+	state.BreachStartedAt = nil
+	return ""
+}
+
+// evaluateAlertRules runs the company's enabled rules over newly stored
+// readings and notifies on every transition.
+func evaluateAlertRules(sensor models.Sensor, readings []models.Reading) {
+	// Held from loading the rules on, so an update that clears their state
+	// cannot be overwritten by an evaluation of the old rule
+	ruleEvalMutex.Lock()
+	defer ruleEvalMutex.Unlock()
+
+	var rules []models.AlertRule
+	err := db.Where("company_id = ? AND enabled AND (sensor_id IS NULL OR sensor_id = ?)", sensor.CompanyID, sensor.ID).
+		Find(&rules).Error
+	if err != nil {
+		log.Printf("Error fetching alert rules for sensor %d: %v", sensor.ID, err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	ordered := append([]models.Reading(nil), readings...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].RecordedAt.Before(ordered[j].RecordedAt) })
+
+	for _, rule := range rules {
+		state := models.AlertRuleState{AlertRuleID: rule.ID, SensorID: sensor.ID}
+		if err := db.Where(&state).FirstOrInit(&state).Error; err != nil {
+			log.Printf("Error loading state of rule %d: %v", rule.ID, err)
+			continue
+		}
+
+		// The state and the notifications it triggered are stored together
+		err := db.Transaction(func(tx *gorm.DB) error {
+			changed := false
+			for _, reading := range ordered {
+				// Late readings cannot be placed in the rule's timeline, skip them
+				if reading.Metric != rule.Metric || !reading.RecordedAt.After(state.LastEvaluatedAt) {
+					continue
+				}
+				changed = true
+				if transition := stepRule(rule, &state, reading); transition != "" {
+					log.Printf("Alert rule %d %s for sensor %d at %v", rule.ID, transition, sensor.ID, reading.Value)
+					if err := notifySensor(tx, sensor, thresholdPayload(sensor, rule, state, transition)); err != nil {
+						return err
+					}
+				}
+			}
+			if !changed {
+				return nil
+			}
+			return tx.Save(&state).Error
+		})
+		if err != nil {
+			log.Printf("Error saving state of rule %d: %v", rule.ID, err)
+		}
+	}
+}
+
+// thresholdPayload builds the notification for a rule transition.
+func thresholdPayload(sensor models.Sensor, rule models.AlertRule, state models.AlertRuleState, transition string) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":         notificationThreshold,
+		"state":        transition,
+		"sensor_id":    sensor.ID,
+		"sensor_name":  sensor.Name,
+		"rule_id":      rule.ID,
+		"rule_name":    rule.Name,
+		"metric":       rule.Metric,
+		"comparator":   rule.Comparator,
+		"threshold":    rule.Threshold,
+		"value":        state.LastValue,
+		"triggered_at": state.TriggeredAt,
+		"timestamp":    time.Now(),
+	}
+}
+
+type AlertRuleRequest struct {
+	SensorID        *uint   `json:"sensor_id"`
+	Name            string  `json:"name"`
+	Metric          string  `json:"metric"`
+	Comparator      string  `json:"comparator"`
+	Threshold       float64 `json:"threshold"`
+	DurationSeconds int     `json:"duration_seconds"`
+	Hysteresis      float64 `json:"hysteresis"`
+	Enabled         *bool   `json:"enabled"`
+}
+
+func (req AlertRuleRequest) validate(companyID uint) error {
+	if req.Name == "" {
+		return errors.New("Name is required")
+	}
+	if !metricPattern.MatchString(req.Metric) {
+		return errors.New("Invalid metric")
+	}
+	switch req.Comparator {
+	case comparatorGT, comparatorGTE, comparatorLT, comparatorLTE:
+	default:
+		return errors.New("Comparator must be gt, gte, lt or lte")
+	}
+	if req.DurationSeconds < 0 || req.Hysteresis < 0 {
+		return errors.New("Duration and hysteresis cannot be negative")
+	}
+	if req.SensorID != nil {
+		var count int64
+		db.Model(&models.Sensor{}).Where("id = ? AND company_id = ?", *req.SensorID, companyID).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("Sensor %d not found", *req.SensorID)
+		}
+	}
+	return nil
+}
+
+func (req AlertRuleRequest) apply(rule *models.AlertRule) {
+	rule.SensorID = req.SensorID
+	rule.Name = req.Name
+	rule.Metric = req.Metric
+	rule.Comparator = req.Comparator
+	rule.Threshold = req.Threshold
+	rule.DurationSeconds = req.DurationSeconds
+	rule.Hysteresis = req.Hysteresis
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+}
+
+// AlertRuleHandler serves /api/alert-rules for the user's company.
+func AlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rules := []models.AlertRule{}
+		if err := db.Where("company_id = ?", user.CompanyID).Order("id").Find(&rules).Error; err != nil {
+			http.Error(w, "Error fetching alert rules", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	case http.MethodPost, http.MethodPut:
+		handleSaveAlertRule(w, r, user.CompanyID)
+	case http.MethodDelete:
+		ruleID := r.URL.Query().Get("id")
+		if ruleID == "" {
+			http.Error(w, "Missing alert rule ID", http.StatusBadRequest)
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Where("id = ? AND company_id = ?", ruleID, user.CompanyID).Delete(&models.AlertRule{})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			return tx.Where("alert_rule_id = ?", ruleID).Delete(&models.AlertRuleState{}).Error
+		})
+		if err != nil {
+			http.Error(w, "Error deleting alert rule", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleSaveAlertRule(w http.ResponseWriter, r *http.Request, companyID uint) {
+	var req AlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(companyID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// New rules are enabled unless the request says otherwise, an update keeps the current state
+	rule := models.AlertRule{CompanyID: companyID, Enabled: true}
+	status := http.StatusCreated
+	if r.Method == http.MethodPut {
+		ruleID := r.URL.Query().Get("id")
+		if ruleID == "" {
+			http.Error(w, "Missing alert rule ID", http.StatusBadRequest)
+			return
+		}
+		if err := db.Where("id = ? AND company_id = ?", ruleID, companyID).First(&rule).Error; err != nil {
+			http.Error(w, "Alert rule not found", http.StatusNotFound)
+			return
+		}
+		status = http.StatusOK
+	}
+
+	// Pending and firing state was measured against the old condition
+	reset := r.Method == http.MethodPut &&
+		(req.Metric != rule.Metric || req.Comparator != rule.Comparator || req.Threshold != rule.Threshold)
+	req.apply(&rule)
+
+	ruleEvalMutex.Lock()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&rule).Error; err != nil {
+			return err
+		}
+		if !reset {
+			return nil
+		}
+		return tx.Where("alert_rule_id = ?", rule.ID).Delete(&models.AlertRuleState{}).Error
+	})
+	ruleEvalMutex.Unlock()
+	if err != nil {
+		http.Error(w, "Error saving alert rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rule)
+}
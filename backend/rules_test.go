@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+func TestStepRule(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	// step is one reading, taken the given number of seconds after start,
+	// and the transition and firing state expected after it
+	type step struct {
+		at         int
+		value      float64
+		transition string
+		firing     bool
+		pending    bool
+	}
+	tests := []struct {
+		name  string
+		rule  models.AlertRule
+		steps []step
+	}{
+		{
+			name: "triggers on the first breach without a duration",
+			rule: models.AlertRule{Comparator: comparatorGT, Threshold: 30},
+			steps: []step{
+				{at: 0, value: 29},
+				{at: 60, value: 30},
+				{at: 120, value: 31, transition: ruleTriggered, firing: true, pending: true},
+				{at: 180, value: 35, firing: true, pending: true},
+			},
+		},
+		{
+			name: "gte and lte include the threshold",
+			rule: models.AlertRule{Comparator: comparatorLTE, Threshold: 0},
+			steps: []step{
+				{at: 0, value: 0, transition: ruleTriggered, firing: true, pending: true},
+				{at: 60, value: 0.5, transition: ruleResolved},
+			},
+		},
+		{
+			name: "waits for the duration",
+			rule: models.AlertRule{Comparator: comparatorGT, Threshold: 30, DurationSeconds: 300},
+			steps: []step{
+				{at: 0, value: 31, pending: true},
+				{at: 120, value: 32, pending: true},
+				{at: 299, value: 33, pending: true},
+				{at: 300, value: 31, transition: ruleTriggered, firing: true, pending: true},
+			},
+		},
+		{
+			name: "a break in the breach restarts the duration",
+			rule: models.AlertRule{Comparator: comparatorGT, Threshold: 30, DurationSeconds: 300},
+			steps: []step{
+				{at: 0, value: 31, pending: true},
+				{at: 200, value: 29},
+				{at: 250, value: 31, pending: true},
+				{at: 500, value: 31, pending: true},
+				{at: 550, value: 31, transition: ruleTriggered, firing: true, pending: true},
+			},
+		},
+		{
+			name: "hysteresis holds the alert until the value clears it",
+			rule: models.AlertRule{Comparator: comparatorGT, Threshold: 30, Hysteresis: 2},
+			steps: []step{
+				{at: 0, value: 31, transition: ruleTriggered, firing: true, pending: true},
+				{at: 60, value: 29, firing: true, pending: true},
+				{at: 120, value: 28, firing: true, pending: true},
+				{at: 180, value: 27.9, transition: ruleResolved},
+				{at: 240, value: 29},
+			},
+		},
+		{
+			name: "hysteresis above a lower threshold",
+			rule: models.AlertRule{Comparator: comparatorLT, Threshold: -10, Hysteresis: 1},
+			steps: []step{
+				{at: 0, value: -12, transition: ruleTriggered, firing: true, pending: true},
+				{at: 60, value: -9.5, firing: true, pending: true},
+				{at: 120, value: -8.9, transition: ruleResolved},
+			},
+		},
+		{
+			name: "a resolved rule fires again after a new duration",
+			rule: models.AlertRule{Comparator: comparatorGT, Threshold: 30, DurationSeconds: 60},
+			steps: []step{
+				{at: 0, value: 31, pending: true},
+				{at: 60, value: 31, transition: ruleTriggered, firing: true, pending: true},
+				{at: 120, value: 20, transition: ruleResolved},
+				{at: 180, value: 31, pending: true},
+				{at: 200, value: 31, pending: true},
+				{at: 240, value: 31, transition: ruleTriggered, firing: true, pending: true},
+			},
+		},
+		{
+			name: "unknown comparator never fires",
+			rule: models.AlertRule{Comparator: "eq", Threshold: 30},
+			steps: []step{
+				{at: 0, value: 30},
+				{at: 60, value: 1e9},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state models.AlertRuleState
+			for _, s := range tt.steps {
+				reading := models.Reading{Value: s.value, RecordedAt: start.Add(time.Duration(s.at) * time.Second)}
+				if got := stepRule(tt.rule, &state, reading); got != s.transition {
+					t.Errorf("at %ds value %v: transition %q, want %q", s.at, s.value, got, s.transition)
+				}
+				if state.Firing != s.firing || (state.BreachStartedAt != nil) != s.pending {
+					t.Errorf("at %ds value %v: firing %v pending %v, want %v %v",
+						s.at, s.value, state.Firing, state.BreachStartedAt != nil, s.firing, s.pending)
+				}
+				if s.transition == ruleTriggered && (state.TriggeredAt == nil || !state.TriggeredAt.Equal(reading.RecordedAt)) {
+					t.Errorf("at %ds: triggered at %v", s.at, state.TriggeredAt)
+				}
+				if state.LastValue != s.value || !state.LastEvaluatedAt.Equal(reading.RecordedAt) {
+					t.Errorf("at %ds: last reading %v at %v not recorded", s.at, state.LastValue, state.LastEvaluatedAt)
+				}
+			}
+		})
+	}
+}
+
+// TestSaveAlertRuleResetsState needs TEST_DATABASE_URL.
+func TestSaveAlertRuleResetsState(t *testing.T) {
+	openTestDB(t)
+	company := models.Company{Name: "Rules"}
+	if err := db.Create(&company).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		update string
+		reset  bool
+	}{
+		{"rename", `{"name":"Renamed","metric":"temperature","comparator":"gt","threshold":30}`, false},
+		{"hysteresis", `{"name":"Heat","metric":"temperature","comparator":"gt","threshold":30,"hysteresis":2}`, false},
+		{"metric", `{"name":"Heat","metric":"humidity","comparator":"gt","threshold":30}`, true},
+		{"comparator", `{"name":"Heat","metric":"temperature","comparator":"gte","threshold":30}`, true},
+		{"threshold", `{"name":"Heat","metric":"temperature","comparator":"gt","threshold":35}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := models.AlertRule{CompanyID: company.ID, Name: "Heat", Metric: "temperature", Comparator: comparatorGT, Threshold: 30, Enabled: true}
+			if err := db.Create(&rule).Error; err != nil {
+				t.Fatal(err)
+			}
+			triggered := time.Now()
+			state := models.AlertRuleState{AlertRuleID: rule.ID, SensorID: 1, BreachStartedAt: &triggered, Firing: true, TriggeredAt: &triggered}
+			if err := db.Create(&state).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, "/api/alert-rules?id="+strconv.Itoa(int(rule.ID)), strings.NewReader(tt.update))
+			handleSaveAlertRule(w, r, company.ID)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+
+			var count int64
+			db.Model(&models.AlertRuleState{}).Where("alert_rule_id = ?", rule.ID).Count(&count)
+			if (count == 0) != tt.reset {
+				t.Errorf("%d states left, want reset %v", count, tt.reset)
+			}
+		})
+	}
+}
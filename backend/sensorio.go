@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxImportRows bounds a single sensor import.
+	maxImportRows = 5000
+	// maxImportBytes bounds the size of an import body.
+	maxImportBytes = 10 << 20
+)
+
+// sensorColumns are the CSV columns used for export. Imports accept them in
+// any order and require name, latitude and longitude.
+var sensorColumns = []string{"name", "latitude", "longitude", "description", "alert_radius_km", "group", "tags"}
+
+// csvTagSeparator separates tags within the CSV tags column.
+const csvTagSeparator = ";"
+
+// importRowError reports why one row of an import was rejected. Row is the
+// 1-based record number, not counting the CSV header.
+type importRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// importResult is returned by the import endpoint. On a dry run, or when any
+// row fails, no sensors are created.
+type importResult struct {
+	DryRun  bool             `json:"dry_run"`
+	Created int              `json:"created"`
+	Sensors []models.Sensor  `json:"sensors"`
+	Errors  []importRowError `json:"errors"`
+}
+
+// validateSensorRequest checks the fields a sensor needs to be matched
+// against warning areas.
+func validateSensorRequest(req SensorRequest) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return errors.New("name is required")
+	}
+	if math.IsNaN(req.Latitude) || req.Latitude < -90 || req.Latitude > 90 {
+		return fmt.Errorf("latitude %v out of range", req.Latitude)
+	}
+	if math.IsNaN(req.Longitude) || req.Longitude < -180 || req.Longitude > 180 {
+		return fmt.Errorf("longitude %v out of range", req.Longitude)
+	}
+	if math.IsNaN(req.AlertRadiusKm) || req.AlertRadiusKm < 0 {
+		return errors.New("alert_radius_km cannot be negative")
+	}
+	return nil
+}
+
+// parseSensorCSV reads sensors from CSV with a header row. Rows that cannot
+// be parsed are reported individually and skipped.
+func parseSensorCSV(r io.Reader) ([]SensorRequest, []importRowError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"name", "latitude", "longitude"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+
+	var requests []SensorRequest
+	var rowErrors []importRowError
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if row > maxImportRows {
+			return nil, nil, fmt.Errorf("at most %d rows per import", maxImportRows)
+		}
+		var req SensorRequest
+		if err == nil {
+			req, err = sensorFromRecord(record, columns)
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				err = parseErr.Err
+			}
+			rowErrors = append(rowErrors, importRowError{Row: row, Error: err.Error()})
+			requests = append(requests, SensorRequest{})
+			continue
+		}
+		requests = append(requests, req)
+	}
+	return requests, rowErrors, nil
+}
+
+func sensorFromRecord(record []string, columns map[string]int) (SensorRequest, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	number := func(name string) (float64, error) {
+		value := field(name)
+		if value == "" {
+			return 0, nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %q is not a number", name, value)
+		}
+		return f, nil
+	}
+
+	text := func(name string) string {
+		return unescapeCSVFormula(field(name))
+	}
+
+	req := SensorRequest{Name: text("name"), Description: text("description"), Group: text("group")}
+	for _, tag := range strings.Split(text("tags"), csvTagSeparator) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			req.Tags = append(req.Tags, tag)
+		}
+	}
+	var err error
+	if field("latitude") == "" || field("longitude") == "" {
+		return req, errors.New("latitude and longitude are required")
+	}
+	if req.Latitude, err = number("latitude"); err != nil {
+		return req, err
+	}
+	if req.Longitude, err = number("longitude"); err != nil {
+		return req, err
+	}
+	if req.AlertRadiusKm, err = number("alert_radius_km"); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// sensorFeatureCollection is the GeoJSON form of a sensor list, one Point
+// feature per sensor.
+type sensorFeatureCollection struct {
+	Type     string          `json:"type"`
+	Features []sensorFeature `json:"features"`
+}
+
+type pointGeometry struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+type sensorFeature struct {
+	Type       string         `json:"type"`
+	Geometry   *pointGeometry `json:"geometry"`
+	Properties struct {
+		ID            uint     `json:"id,omitempty"`
+		Name          string   `json:"name"`
+		Description   string   `json:"description,omitempty"`
+		AlertRadiusKm float64  `json:"alert_radius_km,omitempty"`
+		Group         string   `json:"group,omitempty"`
+		Tags          []string `json:"tags,omitempty"`
+		Status        string   `json:"status,omitempty"`
+	} `json:"properties"`
+}
+
+// parseSensorGeoJSON reads sensors from a FeatureCollection of Points.
+func parseSensorGeoJSON(r io.Reader) ([]SensorRequest, []importRowError, error) {
+	var collection sensorFeatureCollection
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return nil, nil, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, nil, errors.New("expected a FeatureCollection")
+	}
+	if len(collection.Features) > maxImportRows {
+		return nil, nil, fmt.Errorf("at most %d features per import", maxImportRows)
+	}
+
+	requests := make([]SensorRequest, len(collection.Features))
+	var rowErrors []importRowError
+	for i, feature := range collection.Features {
+		geometry := feature.Geometry
+		if geometry == nil || geometry.Type != "Point" || len(geometry.Coordinates) < 2 {
+			rowErrors = append(rowErrors, importRowError{Row: i + 1, Error: "geometry must be a Point"})
+			continue
+		}
+		requests[i] = SensorRequest{
+			Name:          feature.Properties.Name,
+			Longitude:     geometry.Coordinates[0],
+			Latitude:      geometry.Coordinates[1],
+			Description:   feature.Properties.Description,
+			AlertRadiusKm: feature.Properties.AlertRadiusKm,
+			Group:         feature.Properties.Group,
+			Tags:          feature.Properties.Tags,
+		}
+	}
+	return requests, rowErrors, nil
+}
+
+// SensorImportHandler serves POST /api/sensors/import. The body is CSV or a
+// GeoJSON FeatureCollection, chosen by Content-Type. With ?dryRun=true the
+// rows are only validated. Otherwise all sensors are created in a single
+// transaction, or none if any row is invalid.
+func SensorImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var requests []SensorRequest
+	var rowErrors []importRowError
+	switch mediaType {
+	case "text/csv":
+		requests, rowErrors, err = parseSensorCSV(body)
+	case "application/geo+json", "application/json":
+		requests, rowErrors, err = parseSensorGeoJSON(body)
+	default:
+		http.Error(w, "Content-Type must be text/csv or application/geo+json", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	failed := make(map[int]bool, len(rowErrors))
+	for _, rowErr := range rowErrors {
+		failed[rowErr.Row] = true
+	}
+	result := importResult{DryRun: dryRun, Sensors: []models.Sensor{}}
+	for i, req := range requests {
+		row := i + 1
+		if failed[row] {
+			continue
+		}
+		if err := validateSensorRequest(req); err != nil {
+			rowErrors = append(rowErrors, importRowError{Row: row, Error: err.Error()})
+			continue
+		}
+		result.Sensors = append(result.Sensors, models.Sensor{
+			Name:          strings.TrimSpace(req.Name),
+			Latitude:      req.Latitude,
+			Longitude:     req.Longitude,
+			Description:   req.Description,
+			AlertRadiusKm: req.AlertRadiusKm,
+			Group:         req.Group,
+			Tags:          encodeList(req.Tags),
+			Status:        statusOK,
+			CompanyID:     user.CompanyID,
+		})
+	}
+	sort.Slice(rowErrors, func(i, j int) bool { return rowErrors[i].Row < rowErrors[j].Row })
+	result.Errors = rowErrors
+	if result.Errors == nil {
+		result.Errors = []importRowError{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(rowErrors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	if dryRun || len(result.Sensors) == 0 {
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(&result.Sensors, 500).Error; err != nil {
+			return err
+		}
+		for _, sensor := range result.Sensors {
+			if err := recordInitialStatus(tx, sensor); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error importing sensors: %v", err)
+		w.Header().Del("Content-Type")
+		http.Error(w, "Error importing sensors", http.StatusInternalServerError)
+		return
+	}
+	result.Created = len(result.Sensors)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+// writeSensorCSV writes sensors with the export columns. Text cells are
+// escaped with escapeCSVFormula, so they stay text in a spreadsheet.
+func writeSensorCSV(w io.Writer, sensors []models.Sensor) error {
+	writer := csv.NewWriter(w)
+	writer.Write(append([]string{"id"}, append(sensorColumns, "status")...))
+	for _, sensor := range sensors {
+		writer.Write([]string{
+			strconv.FormatUint(uint64(sensor.ID), 10),
+			escapeCSVFormula(sensor.Name),
+			strconv.FormatFloat(sensor.Latitude, 'f', -1, 64),
+			strconv.FormatFloat(sensor.Longitude, 'f', -1, 64),
+			escapeCSVFormula(sensor.Description),
+			strconv.FormatFloat(sensor.AlertRadiusKm, 'f', -1, 64),
+			escapeCSVFormula(sensor.Group),
+			escapeCSVFormula(strings.Join(decodeList[string](sensor.Tags), csvTagSeparator)),
+			sensor.Status,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// isCSVFormula reports whether a spreadsheet would evaluate the cell as a
+// formula, or whether it is such a cell already escaped by escapeCSVFormula.
+func isCSVFormula(s string) bool {
+	if s == "" {
+		return false
+	}
+	switch s[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return true
+	case '\'':
+		return isCSVFormula(s[1:])
+	}
+	return false
+}
+
+// escapeCSVFormula prefixes a cell that would be evaluated as a formula with
+// a quote, which Excel and Sheets take as "treat as text".
+func escapeCSVFormula(s string) string {
+	if isCSVFormula(s) {
+		return "'" + s
+	}
+	return s
+}
+
+// unescapeCSVFormula reverses escapeCSVFormula, so an export imports back
+// unchanged.
+func unescapeCSVFormula(s string) string {
+	if strings.HasPrefix(s, "'") && isCSVFormula(s[1:]) {
+		return s[1:]
+	}
+	return s
+}
+
+// SensorExportHandler serves GET /api/sensors/export?format=csv|geojson with
+// every sensor of the user's company, in a form the import accepts.
+func SensorExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "geojson" {
+		http.Error(w, "Format must be csv or geojson", http.StatusBadRequest)
+		return
+	}
+
+	var sensors []models.Sensor
+	if err := db.Where("company_id = ?", user.CompanyID).Order("id").Find(&sensors).Error; err != nil {
+		http.Error(w, "Error fetching sensors", http.StatusInternalServerError)
+		return
+	}
+	if err := applyMaintenanceStatus(sensors, time.Now()); err != nil {
+		log.Printf("Error checking maintenance windows: %v", err)
+	}
+
+	filename := "sensors-" + time.Now().Format("20060102")
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		if err := writeSensorCSV(w, sensors); err != nil {
+			log.Printf("Error writing sensor export: %v", err)
+		}
+		return
+	}
+
+	collection := sensorFeatureCollection{Type: "FeatureCollection", Features: make([]sensorFeature, len(sensors))}
+	for i, sensor := range sensors {
+		feature := &collection.Features[i]
+		feature.Type = "Feature"
+		feature.Geometry = &pointGeometry{Type: "Point", Coordinates: []float64{sensor.Longitude, sensor.Latitude}}
+		feature.Properties.ID = sensor.ID
+		feature.Properties.Name = sensor.Name
+		feature.Properties.Description = sensor.Description
+		feature.Properties.AlertRadiusKm = sensor.AlertRadiusKm
+		feature.Properties.Group = sensor.Group
+		feature.Properties.Tags = decodeList[string](sensor.Tags)
+		feature.Properties.Status = sensor.Status
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.geojson"`)
+	if err := json.NewEncoder(w).Encode(collection); err != nil {
+		log.Printf("Error writing sensor export: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+func TestParseSensorCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []SensorRequest
+		errors  []importRowError
+		wantErr string
+	}{
+		{
+			name:  "columns in any order",
+			input: "\ufeffLongitude, NAME ,latitude,alert_radius_km\n18.29,Visby,57.63,15\n",
+			want:  []SensorRequest{{Name: "Visby", Latitude: 57.63, Longitude: 18.29, AlertRadiusKm: 15}},
+		},
+		{
+			name:  "all columns",
+			input: "name,latitude,longitude,description,alert_radius_km,group,tags\nVisby, 57.63, 18.29, Harbour,,Gotland,a;b\n",
+			want: []SensorRequest{{
+				Name: "Visby", Latitude: 57.63, Longitude: 18.29, Description: "Harbour",
+				Group: "Gotland", Tags: []string{"a", "b"},
+			}},
+		},
+		{
+			name:  "tag separator",
+			input: "name,latitude,longitude,tags\nVisby,57.63,18.29, roof ;;north side;\n",
+			want:  []SensorRequest{{Name: "Visby", Latitude: 57.63, Longitude: 18.29, Tags: []string{"roof", "north side"}}},
+		},
+		{
+			name:  "escaped formula cells",
+			input: "name,latitude,longitude,group,tags\n'=Visby,57.63,18.29,'-east,'@a;b\n",
+			want: []SensorRequest{{
+				Name: "=Visby", Latitude: 57.63, Longitude: 18.29, Group: "-east", Tags: []string{"@a", "b"},
+			}},
+		},
+		{
+			name: "malformed rows are reported and skipped",
+			input: "name,latitude,longitude\n" +
+				"Visby,57.63,18.29\n" +
+				"Slite,north,18.80\n" +
+				"Fårö,57.92\n" +
+				"Hemse,,18.37\n" +
+				"Klintehamn,57.39,18.20,extra\n" +
+				"Burgsvik,57.04,18.27\n",
+			want: []SensorRequest{
+				{Name: "Visby", Latitude: 57.63, Longitude: 18.29},
+				{},
+				{},
+				{},
+				{},
+				{Name: "Burgsvik", Latitude: 57.04, Longitude: 18.27},
+			},
+			errors: []importRowError{
+				{Row: 2, Error: `latitude: "north" is not a number`},
+				{Row: 3, Error: "wrong number of fields"},
+				{Row: 4, Error: "latitude and longitude are required"},
+				{Row: 5, Error: "wrong number of fields"},
+			},
+		},
+		{
+			name:    "missing required column",
+			input:   "name,latitude,lon\nVisby,57.63,18.29\n",
+			wantErr: "missing longitude column",
+		},
+		{
+			name:    "empty input",
+			input:   "",
+			wantErr: "reading header",
+		},
+		{
+			name:  "header only",
+			input: "name,latitude,longitude\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rowErrors, err := parseSensorCSV(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sensors %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(rowErrors, tt.errors) {
+				t.Errorf("row errors %+v, want %+v", rowErrors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestParseSensorCSVRowLimit(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("name,latitude,longitude\n")
+	for range maxImportRows + 1 {
+		b.WriteString("Visby,57.63,18.29\n")
+	}
+	if _, _, err := parseSensorCSV(strings.NewReader(b.String())); err == nil {
+		t.Fatal("expected an error above maxImportRows")
+	}
+}
+
+func TestParseSensorGeoJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []SensorRequest
+		errors  []importRowError
+		wantErr string
+	}{
+		{
+			name: "points",
+			input: `{"type":"FeatureCollection","features":[
+				{"type":"Feature","geometry":{"type":"Point","coordinates":[18.29,57.63]},
+				 "properties":{"name":"Visby","description":"Harbour","alert_radius_km":15,"group":"Gotland","tags":["a","b"]}}]}`,
+			want: []SensorRequest{{
+				Name: "Visby", Latitude: 57.63, Longitude: 18.29, Description: "Harbour",
+				AlertRadiusKm: 15, Group: "Gotland", Tags: []string{"a", "b"},
+			}},
+		},
+		{
+			name: "features without a point",
+			input: `{"type":"FeatureCollection","features":[
+				{"type":"Feature","properties":{"name":"No geometry"}},
+				{"type":"Feature","geometry":{"type":"MultiPoint","coordinates":[18.80,57.71]},"properties":{"name":"Not a point"}},
+				{"type":"Feature","geometry":{"type":"Point","coordinates":[18]},"properties":{"name":"Short"}},
+				{"type":"Feature","geometry":{"type":"Point","coordinates":[18.80,57.71]},"properties":{"name":"Slite"}}]}`,
+			want: []SensorRequest{{}, {}, {}, {Name: "Slite", Latitude: 57.71, Longitude: 18.80}},
+			errors: []importRowError{
+				{Row: 1, Error: "geometry must be a Point"},
+				{Row: 2, Error: "geometry must be a Point"},
+				{Row: 3, Error: "geometry must be a Point"},
+			},
+		},
+		{
+			name:    "not a collection",
+			input:   `{"type":"Feature","geometry":{"type":"Point","coordinates":[18.29,57.63]}}`,
+			wantErr: "expected a FeatureCollection",
+		},
+		{
+			name:    "invalid JSON",
+			input:   `{"type":"FeatureCollection","features":[`,
+			wantErr: "invalid GeoJSON",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rowErrors, err := parseSensorGeoJSON(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sensors %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(rowErrors, tt.errors) {
+				t.Errorf("row errors %+v, want %+v", rowErrors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestEscapeCSVFormula(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Visby", "Visby"},
+		{"", ""},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+46 8 123", "'+46 8 123"},
+		{"-east", "'-east"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"'=1", "''=1"},
+		{"'quoted", "'quoted"},
+		{"a=b", "a=b"},
+	}
+	for _, tt := range tests {
+		got := escapeCSVFormula(tt.in)
+		if got != tt.want {
+			t.Errorf("escapeCSVFormula(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if back := unescapeCSVFormula(got); back != tt.in {
+			t.Errorf("unescapeCSVFormula(%q) = %q, want %q", got, back, tt.in)
+		}
+	}
+}
+
+func TestSensorCSVRoundTrip(t *testing.T) {
+	sensors := []models.Sensor{
+		{Name: "Visby", Latitude: 57.634, Longitude: 18.294, Description: "Harbour", AlertRadiusKm: 12.5,
+			Group: "Gotland", Tags: encodeList([]string{"roof", "north side"})},
+		{Name: "=cmd|' /C calc'!A0", Latitude: -33.86, Longitude: -151.2, Description: "+46 8 123",
+			Group: "@east", Tags: encodeList([]string{"-a", "b"})},
+		{Name: "'=already quoted", Latitude: 0, Longitude: 0, Description: "Line one\nline two, \"quoted\""},
+	}
+	for i := range sensors {
+		sensors[i].ID = uint(i + 1)
+	}
+
+	var buf bytes.Buffer
+	if err := writeSensorCSV(&buf, sensors); err != nil {
+		t.Fatalf("writing CSV: %v", err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(buf.Bytes())).ReadAll()
+	if err != nil {
+		t.Fatalf("reading export: %v", err)
+	}
+	// Name, description, group and tags are free text
+	for _, record := range records[1:] {
+		for _, i := range []int{1, 4, 6, 7} {
+			if strings.ContainsAny(record[i][:min(1, len(record[i]))], "=+-@\t\r") {
+				t.Errorf("export cell %q would be evaluated as a formula", record[i])
+			}
+		}
+	}
+
+	got, rowErrors, err := parseSensorCSV(&buf)
+	if err != nil || len(rowErrors) > 0 {
+		t.Fatalf("importing export: %v %+v", err, rowErrors)
+	}
+	want := make([]SensorRequest, len(sensors))
+	for i, sensor := range sensors {
+		want[i] = SensorRequest{
+			Name:          sensor.Name,
+			Latitude:      sensor.Latitude,
+			Longitude:     sensor.Longitude,
+			Description:   sensor.Description,
+			AlertRadiusKm: sensor.AlertRadiusKm,
+			Group:         sensor.Group,
+			Tags:          decodeList[string](sensor.Tags),
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip\n got %+v\nwant %+v", got, want)
+	}
+}
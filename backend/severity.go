@@ -0,0 +1,54 @@
+package main
+
+import "strings"
+
+// severity is an SMHI warning level ordered so that levels can be compared.
+type severity int
+
+const (
+	severityNone severity = iota
+	severityYellow
+	severityOrange
+	severityRed
+)
+
+// defaultMinSeverity is the lowest level that notifies a company that has
+// not configured its own threshold.
+const defaultMinSeverity = severityOrange
+
+// parseSeverity maps a WarningLevel code such as "ORANGE" onto a severity.
+// Unknown codes, including SMHI's informational messages, map to severityNone.
+func parseSeverity(code string) severity {
+	switch strings.ToUpper(code) {
+	case "YELLOW":
+		return severityYellow
+	case "ORANGE":
+		return severityOrange
+	case "RED":
+		return severityRed
+	default:
+		return severityNone
+	}
+}
+
+func (s severity) String() string {
+	switch s {
+	case severityYellow:
+		return "YELLOW"
+	case severityOrange:
+		return "ORANGE"
+	case severityRed:
+		return "RED"
+	default:
+		return "NONE"
+	}
+}
+
+// minSeverity returns the company's notification threshold, falling back to
+// defaultMinSeverity when none is stored.
+func minSeverity(code string) severity {
+	if s := parseSeverity(code); s != severityNone {
+		return s
+	}
+	return defaultMinSeverity
+}
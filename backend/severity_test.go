@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestParseSeverity(t *testing.T) {
+	tests := []struct {
+		code string
+		want severity
+	}{
+		{"YELLOW", severityYellow},
+		{"ORANGE", severityOrange},
+		{"RED", severityRed},
+		{"red", severityRed},
+		{"Orange", severityOrange},
+		{"MESSAGE", severityNone},
+		{"PURPLE", severityNone},
+		{"", severityNone},
+	}
+	for _, tt := range tests {
+		if got := parseSeverity(tt.code); got != tt.want {
+			t.Errorf("parseSeverity(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+	if !(severityNone < severityYellow && severityYellow < severityOrange && severityOrange < severityRed) {
+		t.Error("severities are not ordered from none to red")
+	}
+}
+
+func TestSeverityStringRoundTrip(t *testing.T) {
+	for _, s := range []severity{severityYellow, severityOrange, severityRed} {
+		if got := parseSeverity(s.String()); got != s {
+			t.Errorf("parseSeverity(%s) = %v", s, got)
+		}
+	}
+	if got := severityNone.String(); got != "NONE" {
+		t.Errorf("severityNone.String() = %q, want NONE", got)
+	}
+}
+
+func TestMinSeverity(t *testing.T) {
+	tests := []struct {
+		code string
+		want severity
+	}{
+		{"YELLOW", severityYellow},
+		{"ORANGE", severityOrange},
+		{"RED", severityRed},
+		{"", defaultMinSeverity},
+		{"MESSAGE", defaultMinSeverity},
+	}
+	for _, tt := range tests {
+		if got := minSeverity(tt.code); got != tt.want {
+			t.Errorf("minSeverity(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
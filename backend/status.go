@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"gorm.io/gorm"
+)
+
+// Sensor statuses. The weather statuses mirror the SMHI warning levels.
+// MAINTENANCE is never stored, it is shown in place of the stored status
+// while a maintenance window is active.
+const (
+	statusOK          = "OK"
+	statusYellow      = "YELLOW"
+	statusOrange      = "ORANGE"
+	statusRed         = "RED"
+	statusOffline     = "OFFLINE"
+	statusMaintenance = "MAINTENANCE"
+)
+
+// Reasons for a status transition.
+const (
+	reasonCreated          = "created"
+	reasonWarning          = "warning"
+	reasonWarningCleared   = "warning_cleared"
+	reasonOffline          = "offline"
+	reasonOnline           = "online"
+	reasonMaintenanceStart = "maintenance_started"
+	reasonMaintenanceEnd   = "maintenance_ended"
+)
+
+// weatherStatus maps a warning severity onto a sensor status.
+func weatherStatus(level severity) string {
+	switch level {
+	case severityYellow:
+		return statusYellow
+	case severityOrange:
+		return statusOrange
+	case severityRed:
+		return statusRed
+	default:
+		return statusOK
+	}
+}
+
+func isWeatherStatus(status string) bool {
+	switch status {
+	case statusOK, statusYellow, statusOrange, statusRed:
+		return true
+	default:
+		return false
+	}
+}
+
+// statusTransitionAllowed encodes the sensor state machine:
+//
+//   - weather statuses (OK, YELLOW, ORANGE, RED) move between each other on
+//     warnings and cleared warnings
+//   - any weather status can go OFFLINE
+//   - OFFLINE is only left when the sensor is heard from again
+//
+// Maintenance windows do not change the stored status. Their start and end
+// are recorded in the history by recordMaintenanceChange.
+func statusTransitionAllowed(from, to, reason string) bool {
+	if from == to {
+		return false
+	}
+	switch reason {
+	case reasonCreated:
+		return from == "" && to == statusOK
+	case reasonWarning, reasonWarningCleared:
+		return isWeatherStatus(from) && isWeatherStatus(to)
+	case reasonOffline:
+		return isWeatherStatus(from) && to == statusOffline
+	case reasonOnline:
+		return from == statusOffline && isWeatherStatus(to)
+	default:
+		return false
+	}
+}
+
+var errStatusConflict = errors.New("sensor status changed concurrently")
+
+// transitionSensorStatus moves a sensor to a new status and records the
+// change. It returns false without error when the state machine does not
+// allow the transition. The update is conditional on the status the caller
+// saw, so concurrent writers cannot record a transition from a stale state.
+func transitionSensorStatus(tx *gorm.DB, sensor *models.Sensor, to, reason, detail string) (bool, error) {
+	from := sensor.Status
+	if !statusTransitionAllowed(from, to, reason) {
+		return false, nil
+	}
+
+	now := time.Now()
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Sensor{}).
+			Where("id = ? AND status = ?", sensor.ID, from).
+			Update("status", to)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errStatusConflict
+		}
+		return tx.Create(&models.SensorStatusChange{
+			SensorID:  sensor.ID,
+			From:      from,
+			To:        to,
+			Reason:    reason,
+			Detail:    detail,
+			ChangedAt: now,
+		}).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("sensor %d %s -> %s: %w", sensor.ID, from, to, err)
+	}
+	sensor.Status = to
+	return true, nil
+}
+
+// statusTransition is a planned status change of one sensor.
+type statusTransition struct {
+	Sensor *models.Sensor
+	To     string
+	Reason string
+	Detail string
+}
+
+// applyStatusTransitions is the batched form of transitionSensorStatus. The
+// sensors are updated with one conditional UPDATE per from/to pair and their
+// history in one insert. Transitions the state machine does not allow, and
+// sensors whose status changed concurrently, are left out. It returns the
+// IDs of the sensors that were moved and updates their Status.
+func applyStatusTransitions(tx *gorm.DB, transitions []statusTransition) (map[uint]bool, error) {
+	type statusPair struct{ From, To string }
+	groups := make(map[statusPair][]uint)
+	byID := make(map[uint]*statusTransition, len(transitions))
+	for i := range transitions {
+		t := &transitions[i]
+		if !statusTransitionAllowed(t.Sensor.Status, t.To, t.Reason) {
+			continue
+		}
+		pair := statusPair{From: t.Sensor.Status, To: t.To}
+		groups[pair] = append(groups[pair], t.Sensor.ID)
+		byID[t.Sensor.ID] = t
+	}
+
+	now := time.Now()
+	moved := make(map[uint]bool, len(byID))
+	var history []models.SensorStatusChange
+	for pair, ids := range groups {
+		var updated []uint
+		err := tx.Raw(`UPDATE sensors SET status = ?, updated_at = ?
+			WHERE id IN ? AND status = ? AND deleted_at IS NULL
+			RETURNING id`, pair.To, now, ids, pair.From).
+			Scan(&updated).Error
+		if err != nil {
+			return nil, fmt.Errorf("sensors %s -> %s: %w", pair.From, pair.To, err)
+		}
+		if len(updated) < len(ids) {
+			log.Printf("%d sensors changed status concurrently, skipping %s -> %s for them", len(ids)-len(updated), pair.From, pair.To)
+		}
+		for _, id := range updated {
+			t := byID[id]
+			history = append(history, models.SensorStatusChange{
+				SensorID:  id,
+				From:      pair.From,
+				To:        pair.To,
+				Reason:    t.Reason,
+				Detail:    t.Detail,
+				ChangedAt: now,
+			})
+			t.Sensor.Status = pair.To
+			moved[id] = true
+		}
+	}
+	if len(history) > 0 {
+		if err := tx.CreateInBatches(&history, 500).Error; err != nil {
+			return nil, err
+		}
+	}
+	return moved, nil
+}
+
+// effectiveWarningLevel returns the highest level among the active areas in
+// matches that the sensor is inside, and the area it comes from. Nearby
+// areas and levels below threshold do not count.
+func effectiveWarningLevel(matches []areaMatch, threshold severity, now time.Time) (severity, *areaMatch) {
+	effective := severityNone
+	var cause *areaMatch
+	for i, match := range matches {
+		// The level is set per warning area, Event.Code is only the event type
+		level := parseSeverity(match.Area.WarningLevel.Code)
+		if match.Proximity != proximityInside || level == severityNone || level < threshold || !areaActive(*match.Area, now) {
+			continue
+		}
+		if level > effective {
+			effective = level
+			cause = &matches[i]
+		}
+	}
+	return effective, cause
+}
+
+// currentWarning looks a sensor up in the cached warning index and returns
+// the highest active level it is inside and the area it comes from.
+func currentWarning(tx *gorm.DB, sensor models.Sensor, now time.Time) (severity, *areaMatch, error) {
+	var company models.Company
+	if err := tx.Select("id", "min_warning_level").First(&company, sensor.CompanyID).Error; err != nil {
+		return severityNone, nil, err
+	}
+	warningsMutex.Lock()
+	index := cachedIndex
+	warningsMutex.Unlock()
+
+	level, cause := effectiveWarningLevel(index.query(sensor.Latitude, sensor.Longitude, 0), minSeverity(company.MinWarningLevel), now)
+	return level, cause, nil
+}
+
+// currentWeatherStatus returns the status a sensor's active warnings call
+// for, with a detail naming the area that sets it.
+func currentWeatherStatus(tx *gorm.DB, sensor models.Sensor, now time.Time) (string, string, error) {
+	level, cause, err := currentWarning(tx, sensor, now)
+	if err != nil || cause == nil {
+		return weatherStatus(level), "", err
+	}
+	return weatherStatus(level), fmt.Sprintf("warning %d area %d", cause.Warning.ID, cause.Area.ID), nil
+}
+
+// raisingEventCode returns the event code of the warning that last moved a
+// sensor to status at or before at, going by the area named in the history.
+// It is empty when the history names no warning.
+func raisingEventCode(tx *gorm.DB, sensorID uint, status string, at time.Time) (string, error) {
+	var change models.SensorStatusChange
+	err := tx.Where(`sensor_id = ? AND "to" = ? AND changed_at <= ?`, sensorID, status, at).
+		Order("changed_at DESC, id DESC").
+		First(&change).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	var warningID, areaID int
+	if _, err := fmt.Sscanf(change.Detail, "warning %d area %d", &warningID, &areaID); err != nil {
+		return "", nil
+	}
+
+	var records []models.WarningRecord
+	if err := tx.Select("event_code").Where("smhi_id = ?", warningID).Limit(1).Find(&records).Error; err != nil || len(records) == 0 {
+		return "", err
+	}
+	return records[0].EventCode, nil
+}
+
+// weatherTransition plans the move of a sensor to the status of the highest
+// warning level that still covers it, cause being the area it comes from. A
+// sensor is only downgraded, or returned to OK, once no higher warning
+// applies. Sensors that are OFFLINE are left alone.
+func weatherTransition(sensor *models.Sensor, level severity, cause *areaMatch) (statusTransition, bool) {
+	to := weatherStatus(level)
+	if !isWeatherStatus(sensor.Status) || sensor.Status == to {
+		return statusTransition{}, false
+	}
+
+	reason, detail := reasonWarning, ""
+	if cause != nil {
+		detail = fmt.Sprintf("warning %d area %d", cause.Warning.ID, cause.Area.ID)
+	}
+	if level < parseSeverity(sensor.Status) {
+		reason = reasonWarningCleared
+		if cause == nil {
+			detail = "no active warning"
+		}
+	}
+	return statusTransition{Sensor: sensor, To: to, Reason: reason, Detail: detail}, true
+}
+
+// recordInitialStatus writes the first history entry of a new sensor.
+func recordInitialStatus(tx *gorm.DB, sensor models.Sensor) error {
+	return tx.Create(&models.SensorStatusChange{
+		SensorID:  sensor.ID,
+		To:        sensor.Status,
+		Reason:    reasonCreated,
+		ChangedAt: sensor.CreatedAt,
+	}).Error
+}
+
+// StatusHistoryHandler serves GET /api/sensors/{id}/status-history, newest
+// first, with optional from/to bounds and a limit.
+func StatusHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sensor, status, err := findCompanySensor(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	query := r.URL.Query()
+	from, to, err := parseTimeRange(query.Get("from"), query.Get("to"), 30*24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	changes := []models.SensorStatusChange{}
+	err = db.Where("sensor_id = ? AND changed_at >= ? AND changed_at <= ?", sensor.ID, from, to).
+		Order("changed_at DESC, id DESC").
+		Limit(limit).
+		Find(&changes).Error
+	if err != nil {
+		log.Printf("Error fetching status history for sensor %d: %v", sensor.ID, err)
+		http.Error(w, "Error fetching status history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		SensorID uint                        `json:"sensor_id"`
+		Status   string                      `json:"status"`
+		History  []models.SensorStatusChange `json:"history"`
+	}{SensorID: sensor.ID, Status: displayStatus(sensor), History: changes})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEffectiveWarningLevel(t *testing.T) {
+	now := time.Date(2025, 1, 14, 12, 0, 0, 0, time.UTC)
+	match := func(level, proximity, end string) areaMatch {
+		return areaMatch{
+			indexedArea: &indexedArea{
+				Warning: &Warning{ID: 1},
+				Area:    &WarningArea{ID: 1, WarningLevel: WarningLevel{Code: level}, ApproximateEnd: end},
+			},
+			Proximity: proximity,
+		}
+	}
+	tests := []struct {
+		name      string
+		matches   []areaMatch
+		threshold severity
+		want      severity
+	}{
+		{"no warnings", nil, severityYellow, severityNone},
+		{"highest inside area wins", []areaMatch{
+			match("YELLOW", proximityInside, ""),
+			match("RED", proximityInside, "2025-01-15T00:00:00Z"),
+			match("ORANGE", proximityInside, ""),
+		}, severityYellow, severityRed},
+		{"nearby areas do not count", []areaMatch{match("RED", proximityNear, "")}, severityYellow, severityNone},
+		{"ended areas do not count", []areaMatch{match("RED", proximityInside, "2025-01-14T11:00:00Z")}, severityYellow, severityNone},
+		{"below the company threshold", []areaMatch{match("YELLOW", proximityInside, "")}, severityOrange, severityNone},
+		{"informational messages", []areaMatch{match("MESSAGE", proximityInside, "")}, severityYellow, severityNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, cause := effectiveWarningLevel(tt.matches, tt.threshold, now)
+			if got != tt.want {
+				t.Errorf("effectiveWarningLevel = %v, want %v", got, tt.want)
+			}
+			if (cause != nil) != (tt.want != severityNone) {
+				t.Errorf("cause = %v for level %v", cause, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Common metric names. Devices may report others as long as they match
+// metricPattern.
+const (
+	metricTemperature = "temperature"
+	metricHumidity    = "humidity"
+	metricWindSpeed   = "wind_speed"
+	metricWaterLevel  = "water_level"
+)
+
+var metricPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// errInvalidReadings wraps validation failures so handlers can answer 400.
+var errInvalidReadings = errors.New("invalid readings")
+
+const (
+	// maxReadingsPerRequest bounds a single ingestion batch.
+	maxReadingsPerRequest = 1000
+	// maxClockSkew is how far in the future a reading timestamp may be.
+	maxClockSkew = 5 * time.Minute
+	// defaultReadingsLimit caps query results when no limit is given.
+	defaultReadingsLimit = 1000
+	maxReadingsLimit     = 10000
+)
+
+// ReadingInput is one measurement in an ingestion request. RecordedAt
+// defaults to the time the request was received.
+type ReadingInput struct {
+	Metric     string     `json:"metric"`
+	Value      float64    `json:"value"`
+	RecordedAt *time.Time `json:"recorded_at"`
+}
+
+type ReadingsRequest struct {
+	Readings []ReadingInput `json:"readings"`
+}
+
+// validateReadings checks a batch and converts it to rows for the sensor.
+func validateReadings(sensorID uint, inputs []ReadingInput, now time.Time) ([]models.Reading, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("no readings in request")
+	}
+	if len(inputs) > maxReadingsPerRequest {
+		return nil, fmt.Errorf("at most %d readings per request", maxReadingsPerRequest)
+	}
+
+	readings := make([]models.Reading, 0, len(inputs))
+	for i, in := range inputs {
+		if !metricPattern.MatchString(in.Metric) {
+			return nil, fmt.Errorf("reading %d: invalid metric %q", i, in.Metric)
+		}
+		recordedAt := now
+		if in.RecordedAt != nil {
+			recordedAt = *in.RecordedAt
+		}
+		if recordedAt.After(now.Add(maxClockSkew)) {
+			return nil, fmt.Errorf("reading %d: timestamp is in the future", i)
+		}
+		// Older readings would be purged right away and could not be rolled up
+		if recordedAt.Before(now.Add(-readingsRetention)) {
+			return nil, fmt.Errorf("reading %d: timestamp is older than the retention period", i)
+		}
+		readings = append(readings, models.Reading{
+			SensorID:   sensorID,
+			Metric:     in.Metric,
+			RecordedAt: recordedAt.UTC(),
+			Value:      in.Value,
+		})
+	}
+	return readings, nil
+}
+
+// ingestReadings validates and stores readings for a sensor. It is shared by
+// every ingestion path.
+func ingestReadings(sensor models.Sensor, inputs []ReadingInput) ([]models.Reading, error) {
+	readings, err := validateReadings(sensor.ID, inputs, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidReadings, err)
+	}
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sensor_id"}, {Name: "metric"}, {Name: "recorded_at"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value"}),
+	}).Create(&readings).Error
+	if err != nil {
+		return nil, err
+	}
+	markRollupDirty(readings)
+	touchSensor(sensor.ID, time.Now())
+	evaluateAlertRules(sensor, readings)
+	return readings, nil
+}
+
+// findCompanySensor loads a sensor by its path ID, scoped to the user's company.
+func findCompanySensor(r *http.Request, userID uint) (models.Sensor, int, error) {
+	var sensor models.Sensor
+	sensorID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return sensor, http.StatusBadRequest, errors.New("Invalid sensor ID")
+	}
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return sensor, http.StatusNotFound, errors.New("User not found")
+	}
+
+	err = db.Where("id = ? AND company_id = ?", sensorID, user.CompanyID).First(&sensor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return sensor, http.StatusNotFound, errors.New("Sensor not found")
+	} else if err != nil {
+		return sensor, http.StatusInternalServerError, errors.New("Error fetching sensor")
+	}
+	return sensor, http.StatusOK, nil
+}
+
+// ReadingsHandler serves /api/sensors/{id}/readings.
+func ReadingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sensor, status, err := findCompanySensor(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		handleGetReadings(w, r, sensor)
+	case http.MethodPost:
+		handleCreateReadings(w, r, sensor)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleCreateReadings(w http.ResponseWriter, r *http.Request, sensor models.Sensor) {
+	var req ReadingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
+		return
+	}
+
+	readings, err := ingestReadings(sensor, req.Readings)
+	if errors.Is(err, errInvalidReadings) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("Error storing readings for sensor %d: %v", sensor.ID, err)
+		http.Error(w, "Error storing readings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Accepted int `json:"accepted"`
+	}{Accepted: len(readings)})
+}
+
+// handleGetReadings returns raw readings in [from, to], oldest first.
+func handleGetReadings(w http.ResponseWriter, r *http.Request, sensor models.Sensor) {
+	query := r.URL.Query()
+	from, to, err := parseTimeRange(query.Get("from"), query.Get("to"), 24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit < 1 {
+		limit = defaultReadingsLimit
+	}
+	if limit > maxReadingsLimit {
+		limit = maxReadingsLimit
+	}
+
+	q := db.Where("sensor_id = ? AND recorded_at >= ? AND recorded_at <= ?", sensor.ID, from, to)
+	if metric := query.Get("metric"); metric != "" {
+		q = q.Where("metric = ?", metric)
+	}
+
+	readings := []models.Reading{}
+	if err := q.Order("recorded_at, metric").Limit(limit).Find(&readings).Error; err != nil {
+		http.Error(w, "Error fetching readings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		SensorID uint             `json:"sensor_id"`
+		From     time.Time        `json:"from"`
+		To       time.Time        `json:"to"`
+		Readings []models.Reading `json:"readings"`
+	}{SensorID: sensor.ID, From: from, To: to, Readings: readings})
+}
+
+// parseTimeRange parses optional RFC 3339 bounds. A missing "to" means now
+// and a missing "from" means defaultSpan before "to".
+func parseTimeRange(fromValue, toValue string, defaultSpan time.Duration) (time.Time, time.Time, error) {
+	to := time.Now()
+	if toValue != "" {
+		t, err := time.Parse(time.RFC3339, toValue)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("Invalid to timestamp")
+		}
+		to = t
+	}
+	from := to.Add(-defaultSpan)
+	if fromValue != "" {
+		t, err := time.Parse(time.RFC3339, fromValue)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("Invalid from timestamp")
+		}
+		from = t
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, errors.New("to must not be before from")
+	}
+	return from, to, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Rohnson95/weathermonitoring/backend/models"
+)
+
+// webhookSecretScheme prefixes webhook signing secrets.
+const webhookSecretScheme = "whsec_"
+
+// webhookClient sends webhook deliveries.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookResponse is returned when a webhook is created or its secret
+// rotated, the only times the secret is shown.
+type webhookResponse struct {
+	models.Webhook
+	Secret string `json:"secret"`
+}
+
+func generateWebhookSecret() (string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	return webhookSecretScheme + secret, nil
+}
+
+// newDeliveryID returns a random ID identifying one webhook delivery.
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error generating delivery ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// webhookSecrets returns the secrets a delivery is signed with, the current
+// one and, during a rotation overlap, the previous one.
+func webhookSecrets(webhook models.Webhook, now time.Time) []string {
+	secrets := []string{webhook.Secret}
+	if webhook.PreviousSecret != "" && webhook.PreviousSecretExpiresAt != nil && now.Before(*webhook.PreviousSecretExpiresAt) {
+		secrets = append(secrets, webhook.PreviousSecret)
+	}
+	return secrets
+}
+
+// ensureWebhookSecrets gives a secret to every webhook created before
+// deliveries were signed.
+func ensureWebhookSecrets() error {
+	var webhooks []models.Webhook
+	if err := db.Where("secret = ''").Find(&webhooks).Error; err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return err
+		}
+		if err := db.Model(&webhook).Update("secret", secret).Error; err != nil {
+			return err
+		}
+	}
+	if len(webhooks) > 0 {
+		log.Printf("Generated signing secrets for %d webhooks", len(webhooks))
+	}
+	return nil
+}
+
+// RotateWebhookSecretHandler serves POST /api/webhooks/{id}/rotate-secret.
+// The old secret keeps signing deliveries for ?grace= (default 24h) next to
+// the new one.
+func RotateWebhookSecretHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	webhook, ok := findUserWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	grace := defaultRotationGrace
+	if v := r.URL.Query().Get("grace"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "Invalid grace period", http.StatusBadRequest)
+			return
+		}
+		grace = d
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		log.Printf("Error generating webhook secret: %v", err)
+		http.Error(w, "Error rotating secret", http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(grace)
+	err = db.Model(&webhook).Updates(map[string]interface{}{
+		"secret":                     secret,
+		"previous_secret":            webhook.Secret,
+		"previous_secret_expires_at": expiresAt,
+	}).Error
+	if err != nil {
+		log.Printf("Error rotating secret of webhook %d: %v", webhook.ID, err)
+		http.Error(w, "Error rotating secret", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhookResponse{Webhook: webhook, Secret: secret})
+}
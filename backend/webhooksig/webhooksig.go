@@ -0,0 +1,122 @@
+// Package webhooksig signs and verifies webhook deliveries.
+//
+// Every delivery carries three headers:
+//
+//	X-Webhook-ID:        unique delivery ID, stable across retries
+//	X-Webhook-Timestamp: Unix time in seconds when the delivery was signed
+//	X-Webhook-Signature: one or more "v1=<hex>" entries separated by ", "
+//
+// Each signature is the hex HMAC-SHA256 of "<timestamp>.<body>" under a
+// webhook secret. While a secret is being rotated, deliveries are signed with
+// both the new and the previous secret, so a receiver can switch secrets at
+// any point during the overlap.
+//
+// A receiver only needs Verify:
+//
+//	body, _ := io.ReadAll(r.Body)
+//	if err := webhooksig.Verify(secret, r.Header, body, webhooksig.DefaultTolerance); err != nil {
+//		http.Error(w, "invalid signature", http.StatusUnauthorized)
+//		return
+//	}
+package webhooksig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header names set on every delivery.
+const (
+	HeaderID        = "X-Webhook-ID"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// signatureVersion prefixes each signature so the scheme can change later.
+const signatureVersion = "v1"
+
+// DefaultTolerance is how far a delivery's timestamp may be from the
+// receiver's clock before it is rejected as a possible replay.
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrMissingHeader    = errors.New("webhooksig: missing signature headers")
+	ErrInvalidTimestamp = errors.New("webhooksig: invalid timestamp")
+	ErrTimestampSkew    = errors.New("webhooksig: timestamp outside tolerance")
+	ErrNoValidSignature = errors.New("webhooksig: no valid signature")
+)
+
+// Sign returns the signature of body at timestamp under secret, without the
+// version prefix.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureHeader builds the X-Webhook-Signature value with one entry per
+// secret. Empty secrets are skipped.
+func SignatureHeader(timestamp time.Time, body []byte, secrets ...string) string {
+	var entries []string
+	for _, secret := range secrets {
+		if secret != "" {
+			entries = append(entries, signatureVersion+"="+Sign(secret, timestamp, body))
+		}
+	}
+	return strings.Join(entries, ", ")
+}
+
+// SetHeaders sets the ID, timestamp and signature headers on h.
+func SetHeaders(h http.Header, deliveryID string, timestamp time.Time, body []byte, secrets ...string) {
+	h.Set(HeaderID, deliveryID)
+	h.Set(HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+	h.Set(HeaderSignature, SignatureHeader(timestamp, body, secrets...))
+}
+
+// Verify checks that body was signed with secret and that the timestamp is
+// within tolerance of now. A tolerance of zero disables the timestamp check.
+func Verify(secret string, h http.Header, body []byte, tolerance time.Duration) error {
+	return verifyAt(secret, h, body, tolerance, time.Now())
+}
+
+func verifyAt(secret string, h http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	rawTimestamp, rawSignature := h.Get(HeaderTimestamp), h.Get(HeaderSignature)
+	if rawTimestamp == "" || rawSignature == "" {
+		return ErrMissingHeader
+	}
+	seconds, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	timestamp := time.Unix(seconds, 0)
+	if tolerance > 0 {
+		skew := now.Sub(timestamp)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > tolerance {
+			return ErrTimestampSkew
+		}
+	}
+
+	expected, _ := hex.DecodeString(Sign(secret, timestamp, body))
+	for _, entry := range strings.Split(rawSignature, ",") {
+		version, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || version != signatureVersion {
+			continue
+		}
+		given, err := hex.DecodeString(value)
+		if err == nil && hmac.Equal(given, expected) {
+			return nil
+		}
+	}
+	return ErrNoValidSignature
+}
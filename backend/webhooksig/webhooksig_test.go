@@ -0,0 +1,161 @@
+package webhooksig
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSecret     = "whsec_current"
+	testOldSecret  = "whsec_previous"
+	testDeliveryID = "d3f1c2a4-delivery"
+)
+
+var (
+	signedAt = time.Unix(1736848800, 0)
+	testBody = []byte(`{"kind":"warning","sensor_id":42,"warning_level":"RED"}`)
+)
+
+func signedHeaders(body []byte, secrets ...string) http.Header {
+	h := make(http.Header)
+	SetHeaders(h, testDeliveryID, signedAt, body, secrets...)
+	return h
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	h := signedHeaders(testBody, testSecret)
+	if got := h.Get(HeaderID); got != testDeliveryID {
+		t.Errorf("%s = %q, want %q", HeaderID, got, testDeliveryID)
+	}
+	if got := h.Get(HeaderTimestamp); got != strconv.FormatInt(signedAt.Unix(), 10) {
+		t.Errorf("%s = %q", HeaderTimestamp, got)
+	}
+	if got, want := h.Get(HeaderSignature), "v1="+Sign(testSecret, signedAt, testBody); got != want {
+		t.Errorf("%s = %q, want %q", HeaderSignature, got, want)
+	}
+	if err := verifyAt(testSecret, h, testBody, DefaultTolerance, signedAt.Add(time.Minute)); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := verifyAt("whsec_other", h, testBody, DefaultTolerance, signedAt); !errors.Is(err, ErrNoValidSignature) {
+		t.Errorf("verify with another secret = %v, want ErrNoValidSignature", err)
+	}
+}
+
+func TestSignIsStable(t *testing.T) {
+	// HMAC-SHA256 of "1736848800.{}" under "secret"
+	const want = "8867e3e5980eea7126a5a532b83b700c34a5484ec5cc09f1bc60ef736155f095"
+	if got := Sign("secret", signedAt, []byte("{}")); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestVerifyDuringRotation(t *testing.T) {
+	h := signedHeaders(testBody, testSecret, testOldSecret)
+	if entries := strings.Split(h.Get(HeaderSignature), ", "); len(entries) != 2 {
+		t.Fatalf("signature header %q, want two entries", h.Get(HeaderSignature))
+	}
+	for _, secret := range []string{testSecret, testOldSecret} {
+		if err := verifyAt(secret, h, testBody, DefaultTolerance, signedAt); err != nil {
+			t.Errorf("verify with %s: %v", secret, err)
+		}
+	}
+
+	// Once the overlap ends only the new secret signs
+	h = signedHeaders(testBody, testSecret, "")
+	if err := verifyAt(testOldSecret, h, testBody, DefaultTolerance, signedAt); !errors.Is(err, ErrNoValidSignature) {
+		t.Errorf("verify with the expired secret = %v, want ErrNoValidSignature", err)
+	}
+}
+
+func TestVerifyTimestamp(t *testing.T) {
+	h := signedHeaders(testBody, testSecret)
+	tests := []struct {
+		name      string
+		now       time.Time
+		tolerance time.Duration
+		want      error
+	}{
+		{"at the edge of the tolerance", signedAt.Add(DefaultTolerance), DefaultTolerance, nil},
+		{"too old", signedAt.Add(DefaultTolerance + time.Second), DefaultTolerance, ErrTimestampSkew},
+		{"from the future", signedAt.Add(-DefaultTolerance - time.Second), DefaultTolerance, ErrTimestampSkew},
+		{"tolerance disabled", signedAt.Add(24 * time.Hour), 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyAt(testSecret, h, testBody, tt.tolerance, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	h.Set(HeaderTimestamp, "yesterday")
+	if err := verifyAt(testSecret, h, testBody, DefaultTolerance, signedAt); !errors.Is(err, ErrInvalidTimestamp) {
+		t.Errorf("verify with a malformed timestamp = %v, want ErrInvalidTimestamp", err)
+	}
+
+	// Moving the timestamp inside the tolerance does not help, it is signed
+	h = signedHeaders(testBody, testSecret)
+	h.Set(HeaderTimestamp, strconv.FormatInt(signedAt.Unix()+1, 10))
+	if err := verifyAt(testSecret, h, testBody, DefaultTolerance, signedAt); !errors.Is(err, ErrNoValidSignature) {
+		t.Errorf("verify with a changed timestamp = %v, want ErrNoValidSignature", err)
+	}
+}
+
+func TestVerifyTamperedBody(t *testing.T) {
+	h := signedHeaders(testBody, testSecret)
+	tampered := []byte(strings.Replace(string(testBody), "RED", "YELLOW", 1))
+	if err := verifyAt(testSecret, h, tampered, DefaultTolerance, signedAt); !errors.Is(err, ErrNoValidSignature) {
+		t.Errorf("verify tampered body = %v, want ErrNoValidSignature", err)
+	}
+	if err := verifyAt(testSecret, h, append(testBody, '\n'), DefaultTolerance, signedAt); !errors.Is(err, ErrNoValidSignature) {
+		t.Errorf("verify body with trailing newline = %v, want ErrNoValidSignature", err)
+	}
+}
+
+func TestVerifySignatureHeader(t *testing.T) {
+	valid := "v1=" + Sign(testSecret, signedAt, testBody)
+	other := "v1=" + Sign("whsec_other", signedAt, testBody)
+	flipped := []byte(valid)
+	if flipped[len(flipped)-1] == '0' {
+		flipped[len(flipped)-1] = '1'
+	} else {
+		flipped[len(flipped)-1] = '0'
+	}
+
+	tests := []struct {
+		name      string
+		signature string
+		want      error
+	}{
+		{"missing", "", ErrMissingHeader},
+		{"one changed digit", string(flipped), ErrNoValidSignature},
+		{"not hex", "v1=zzzz", ErrNoValidSignature},
+		{"no version", Sign(testSecret, signedAt, testBody), ErrNoValidSignature},
+		{"unknown version", "v2=" + Sign(testSecret, signedAt, testBody), ErrNoValidSignature},
+		{"empty value", "v1=", ErrNoValidSignature},
+		{"valid entry last", other + ", " + valid, nil},
+		{"valid entry first", valid + ", " + other, nil},
+		{"without spaces", other + "," + valid, nil},
+		{"among unknown versions", "v0=abc, " + valid + ", v2=def", nil},
+		{"several invalid entries", other + ", " + string(flipped), ErrNoValidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := signedHeaders(testBody, testSecret)
+			h.Set(HeaderSignature, tt.signature)
+			if err := verifyAt(testSecret, h, testBody, DefaultTolerance, signedAt); !errors.Is(err, tt.want) {
+				t.Errorf("verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	h := signedHeaders(testBody, testSecret)
+	h.Del(HeaderTimestamp)
+	if err := verifyAt(testSecret, h, testBody, DefaultTolerance, signedAt); !errors.Is(err, ErrMissingHeader) {
+		t.Errorf("verify without a timestamp = %v, want ErrMissingHeader", err)
+	}
+}